@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// checkConfig prints every error of the loaded config, then checks that the tailscaled of the
+// config answer a status call. It reports whether everything is fine.
+func checkConfig(cfg Config, loadErr error) bool {
+	if loadErr != nil {
+		fmt.Fprintln(os.Stderr, "config is invalid:")
+		for _, err := range flattenErrors(loadErr) {
+			fmt.Fprintf(os.Stderr, "  - %s\n", err)
+		}
+		return false
+	}
+	ok := true
+	if !cfg.Tsnet.Enabled {
+		// the tsnet node only exists while the exporter runs
+		provider, _ := newStatusProvider(cfg)
+		if err := checkStatus(provider, cfg.Tailscale.Timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "tailscaled is not reachable: %s\n", err)
+			ok = false
+		}
+		for name, socket := range cfg.Tailscale.Instances {
+			provider := &tailscaleclient.ExecProvider{CLI: tailscaleclient.CLI{Binary: cfg.Tailscale.Binary, Socket: socket}}
+			if err := checkStatus(provider, cfg.Tailscale.Timeout); err != nil {
+				fmt.Fprintf(os.Stderr, "tailscaled of instance %s is not reachable: %s\n", name, err)
+				ok = false
+			}
+		}
+	}
+	if ok {
+		fmt.Println("config is valid")
+	}
+	return ok
+}
+
+func checkStatus(provider tailscaleclient.StatusProvider, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := provider.Status(ctx)
+	return err
+}
+
+// flattenErrors splits errors joined by Validate, so each is reported on its own line.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+	return errs
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+var (
+	PeerRxAdjustedDesc    = prometheus.V2.NewDesc("peer_rx_adjusted_bytes_total", "Bytes received from the peer, kept monotonic across resets of peer_rx.", prometheus.UnconstrainedLabels(dynLabels), nil, prometheus.WithUnit("bytes"))
+	PeerTxAdjustedDesc    = prometheus.V2.NewDesc("peer_tx_adjusted_bytes_total", "Bytes sent to the peer, kept monotonic across resets of peer_tx.", prometheus.UnconstrainedLabels(dynLabels), nil, prometheus.WithUnit("bytes"))
+	PeerCounterResetsDesc = prometheus.NewDesc("peer_counter_resets_total", "Number of detected resets of peer_rx or peer_tx, e.g. tailscaled restarts or reconnections.", dynLabels, nil)
+)
+
+// CounterAccumulator keeps the byte counters of the peers monotonic. tailscaled resets RxBytes and TxBytes
+// when it restarts or reconnects to a peer, which shows as dips in rate(). A counter going down is taken
+// as a reset, its new value as the traffic since.
+type CounterAccumulator struct {
+	// Path persists the totals, so they survive exporter restarts too. Empty keeps them in memory only.
+	Path     string
+	Interval time.Duration
+
+	mu    sync.Mutex
+	peers map[string]AccumulatedCounters
+	dirty bool
+}
+
+// AccumulatedCounters are the adjusted totals of one peer, with the last raw values to detect resets.
+type AccumulatedCounters struct {
+	LastRx int   `json:"last_rx"`
+	LastTx int   `json:"last_tx"`
+	Rx     int64 `json:"rx"`
+	Tx     int64 `json:"tx"`
+	Resets int   `json:"resets"`
+}
+
+// Load reads the totals saved at Path. A missing file starts from zero.
+func (accumulator *CounterAccumulator) Load() error {
+	accumulator.mu.Lock()
+	defer accumulator.mu.Unlock()
+	accumulator.peers = map[string]AccumulatedCounters{}
+	if accumulator.Path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(accumulator.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error on read counter state: %w", err)
+	}
+	if err := json.Unmarshal(raw, &accumulator.peers); err != nil {
+		return fmt.Errorf("error on unmarshal counter state %s: %w", accumulator.Path, err)
+	}
+	return nil
+}
+
+// Run saves the totals every Interval. Call Save once more on shutdown.
+func (accumulator *CounterAccumulator) Run(ctx context.Context) {
+	if accumulator.Path == "" {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(accumulator.Interval):
+		}
+		if err := accumulator.Save(); err != nil {
+			slog.Error("error on save counter state", "err", err)
+		}
+	}
+}
+
+// Save replaces Path atomically when the totals changed since the last save.
+func (accumulator *CounterAccumulator) Save() error {
+	accumulator.mu.Lock()
+	defer accumulator.mu.Unlock()
+	if accumulator.Path == "" || !accumulator.dirty {
+		return nil
+	}
+	content, err := json.Marshal(accumulator.peers)
+	if err != nil {
+		return fmt.Errorf("error on marshal counter state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(accumulator.Path), "."+filepath.Base(accumulator.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("error on create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error on write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error on close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), accumulator.Path); err != nil {
+		return fmt.Errorf("error on rename temp file: %w", err)
+	}
+	accumulator.dirty = false
+	return nil
+}
+
+// Observe adds the traffic since the previous status and returns the totals per peer ID.
+// Peers gone from the status are forgotten.
+func (accumulator *CounterAccumulator) Observe(status *tailscaleclient.Status) map[string]AccumulatedCounters {
+	accumulator.mu.Lock()
+	defer accumulator.mu.Unlock()
+	peers := make(map[string]AccumulatedCounters, len(status.Peer))
+	for _, peer := range status.Peer {
+		counters, ok := accumulator.peers[peer.ID]
+		if !ok {
+			counters = AccumulatedCounters{LastRx: peer.RxBytes, LastTx: peer.TxBytes, Rx: int64(peer.RxBytes), Tx: int64(peer.TxBytes)}
+		}
+		if peer.RxBytes < counters.LastRx || peer.TxBytes < counters.LastTx {
+			counters.Resets++
+		}
+		counters.Rx += accumulatedDelta(counters.LastRx, peer.RxBytes)
+		counters.Tx += accumulatedDelta(counters.LastTx, peer.TxBytes)
+		counters.LastRx, counters.LastTx = peer.RxBytes, peer.TxBytes
+		peers[peer.ID] = counters
+	}
+	accumulator.peers = peers
+	accumulator.dirty = true
+	return peers
+}
+
+// accumulatedDelta is the traffic between two raw counter values, all of current after a reset.
+func accumulatedDelta(last int, current int) int64 {
+	if current < last {
+		return int64(current)
+	}
+	return int64(current - last)
+}
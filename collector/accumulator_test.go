@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"path/filepath"
+	"tailscale-exporter/tailscaleclient"
+	"testing"
+)
+
+// peerStatus returns a status of one peer p1 with the raw byte counters.
+func peerStatus(rx int, tx int) *tailscaleclient.Status {
+	return &tailscaleclient.Status{Peer: map[string]tailscaleclient.Peer{"k1": {ID: "p1", RxBytes: rx, TxBytes: tx}}}
+}
+
+func TestCounterAccumulatorObserve(t *testing.T) {
+	accumulator := &CounterAccumulator{}
+	if err := accumulator.Load(); err != nil {
+		t.Fatalf("error on load: %v", err)
+	}
+	tests := []struct {
+		name   string
+		rx, tx int
+		want   AccumulatedCounters
+	}{
+		{"first status", 100, 50, AccumulatedCounters{LastRx: 100, LastTx: 50, Rx: 100, Tx: 50}},
+		{"growth", 150, 80, AccumulatedCounters{LastRx: 150, LastTx: 80, Rx: 150, Tx: 80}},
+		{"reset", 30, 10, AccumulatedCounters{LastRx: 30, LastTx: 10, Rx: 180, Tx: 90, Resets: 1}},
+		{"reset of rx only", 5, 20, AccumulatedCounters{LastRx: 5, LastTx: 20, Rx: 185, Tx: 100, Resets: 2}},
+	}
+	for _, test := range tests {
+		if got := accumulator.Observe(peerStatus(test.rx, test.tx))["p1"]; got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+	if peers := accumulator.Observe(&tailscaleclient.Status{}); len(peers) != 0 {
+		t.Errorf("got %v, want peers gone from the status forgotten", peers)
+	}
+}
+
+func TestCounterAccumulatorPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	accumulator := &CounterAccumulator{Path: path}
+	if err := accumulator.Load(); err != nil {
+		t.Fatalf("error on load of a missing file: %v", err)
+	}
+	accumulator.Observe(peerStatus(100, 50))
+	accumulator.Observe(peerStatus(10, 5))
+	if err := accumulator.Save(); err != nil {
+		t.Fatalf("error on save: %v", err)
+	}
+
+	restarted := &CounterAccumulator{Path: path}
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("error on load: %v", err)
+	}
+	want := AccumulatedCounters{LastRx: 20, LastTx: 5, Rx: 120, Tx: 55, Resets: 1}
+	if got := restarted.Observe(peerStatus(20, 5))["p1"]; got != want {
+		t.Errorf("after restart: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2/clientcredentials"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdminAPIClient talks to the Tailscale Admin API (https://tailscale.com/api) with an API key or an OAuth client.
+type AdminAPIClient struct {
+	BaseURL string
+	Tailnet string
+	APIKey  string
+
+	httpClient *http.Client
+}
+
+func NewAdminAPIClient(baseURL string, tailnet string, apiKey string, oauthClientID string, oauthClientSecret string) *AdminAPIClient {
+	client := &AdminAPIClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Tailnet:    tailnet,
+		APIKey:     apiKey,
+		httpClient: http.DefaultClient,
+	}
+	if oauthClientID != "" {
+		oauthConfig := clientcredentials.Config{
+			ClientID:     oauthClientID,
+			ClientSecret: oauthClientSecret,
+			TokenURL:     client.BaseURL + "/api/v2/oauth/token",
+		}
+		client.httpClient = oauthConfig.Client(context.Background())
+	}
+	return client
+}
+
+type AdminAPIDevice struct {
+	ID                string    `json:"id"`
+	NodeID            string    `json:"nodeId"`
+	Name              string    `json:"name"`
+	Hostname          string    `json:"hostname"`
+	User              string    `json:"user"`
+	OS                string    `json:"os"`
+	ClientVersion     string    `json:"clientVersion"`
+	UpdateAvailable   bool      `json:"updateAvailable"`
+	Authorized        bool      `json:"authorized"`
+	IsExternal        bool      `json:"isExternal"`
+	KeyExpiryDisabled bool      `json:"keyExpiryDisabled"`
+	Created           time.Time `json:"created"`
+	LastSeen          time.Time `json:"lastSeen"`
+	Expires           time.Time `json:"expires"`
+	Tags              []string  `json:"tags"`
+	Addresses         []string  `json:"addresses"`
+	AdvertisedRoutes  []string  `json:"advertisedRoutes"`
+	EnabledRoutes     []string  `json:"enabledRoutes"`
+}
+
+func (client *AdminAPIClient) ListDevices(ctx context.Context) ([]AdminAPIDevice, error) {
+	response := struct {
+		Devices []AdminAPIDevice `json:"devices"`
+	}{}
+	if err := client.get(ctx, "/devices?fields=all", &response); err != nil {
+		return nil, err
+	}
+	return response.Devices, nil
+}
+
+// GetPostureAttributes returns the posture attributes of the device, keyed like custom:name or node:os.
+func (client *AdminAPIClient) GetPostureAttributes(ctx context.Context, deviceID string) (map[string]any, error) {
+	path := "/device/" + url.PathEscape(deviceID) + "/attributes"
+	body, _, err := client.fetch(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	response := struct {
+		Attributes map[string]any `json:"attributes"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error on unmarshal admin api %s: %w", path, err)
+	}
+	return response.Attributes, nil
+}
+
+// get calls a tailnet scoped endpoint: path is relative to /api/v2/tailnet/{tailnet}.
+func (client *AdminAPIClient) get(ctx context.Context, path string, v any) error {
+	body, _, err := client.getRaw(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error on unmarshal admin api %s: %w", path, err)
+	}
+	return nil
+}
+
+// getRaw calls a tailnet scoped endpoint like get, returning the body and headers of the response.
+func (client *AdminAPIClient) getRaw(ctx context.Context, path string) ([]byte, http.Header, error) {
+	return client.fetch(ctx, "/tailnet/"+url.PathEscape(client.Tailnet)+path)
+}
+
+// fetch calls an endpoint of any scope: path is relative to /api/v2.
+func (client *AdminAPIClient) fetch(ctx context.Context, path string) ([]byte, http.Header, error) {
+	endpoint := client.BaseURL + "/api/v2" + path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.APIKey != "" {
+		request.SetBasicAuth(client.APIKey, "")
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on admin api %s: %w", path, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on read admin api %s: %w", path, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("error on admin api %s: status %d: %s", path, response.StatusCode, string(body))
+	}
+	return body, response.Header, nil
+}
+
+var deviceLabels = []string{"device_id", "device_name"}
+var (
+	AdminAPIUpDesc             = prometheus.NewDesc("admin_api_up", "Whether the last Admin API collection succeeded.", nil, nil)
+	DeviceInfoDesc             = prometheus.NewDesc("device_info", "Device metadata from the Admin API.", append(slices.Clone(deviceLabels), "hostname", "user", "os", "client_version", "tags"), nil)
+	DeviceAuthorizedDesc       = prometheus.NewDesc("device_authorized", "Whether the device is authorized to join the tailnet.", deviceLabels, nil)
+	DeviceUpdateAvailableDesc  = prometheus.NewDesc("device_update_available", "Whether a Tailscale client update is available for the device.", deviceLabels, nil)
+	DeviceLastSeenDesc         = prometheus.NewDesc("device_last_seen_timestamp_seconds", "When the device was last seen by the control plane.", deviceLabels, nil)
+	DeviceKeyExpiryDesc        = prometheus.NewDesc("device_key_expiry_timestamp_seconds", "When the device node key expires. Missing if key expiry is disabled.", deviceLabels, nil)
+	DeviceRoutesAdvertisedDesc = prometheus.NewDesc("device_routes_advertised", "Number of subnet routes advertised by the device.", deviceLabels, nil)
+	DeviceRoutesEnabledDesc    = prometheus.NewDesc("device_routes_enabled", "Number of subnet routes approved for the device.", deviceLabels, nil)
+	DeviceRouteUnapprovedDesc  = prometheus.NewDesc("device_route_unapproved", "Subnet route or exit node route advertised by the device and waiting for approval, always 1.", append(slices.Clone(deviceLabels), "route"), nil)
+	DevicePostureDesc          = prometheus.NewDesc("device_posture_attribute", "Boolean or numeric posture attribute of the device, booleans as 0 or 1.", append(slices.Clone(deviceLabels), "attribute"), nil)
+	DevicePostureInfoDesc      = prometheus.NewDesc("device_posture_attribute_info", "String posture attribute of the device, always 1.", append(slices.Clone(deviceLabels), "attribute", "value"), nil)
+)
+
+// AdminAPICollector exports tailnet-wide device metrics from the Admin API.
+type AdminAPICollector struct {
+	Client  *AdminAPIClient
+	Timeout time.Duration
+	// PostureAttributes are exported for every device, each costs an API call per device
+	PostureAttributes []string
+}
+
+func (collector *AdminAPICollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- AdminAPIUpDesc
+	ch <- DeviceInfoDesc
+	ch <- DeviceAuthorizedDesc
+	ch <- DeviceUpdateAvailableDesc
+	ch <- DeviceLastSeenDesc
+	ch <- DeviceKeyExpiryDesc
+	ch <- DeviceRoutesAdvertisedDesc
+	ch <- DeviceRoutesEnabledDesc
+	ch <- DeviceRouteUnapprovedDesc
+	ch <- DevicePostureDesc
+	ch <- DevicePostureInfoDesc
+}
+
+func (collector *AdminAPICollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *AdminAPICollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	devices, err := collector.Client.ListDevices(ctx)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(AdminAPIUpDesc, prometheus.GaugeValue, 0)
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(AdminAPIUpDesc, prometheus.GaugeValue, 1)
+	for _, device := range devices {
+		labels := []string{device.NodeID, device.Name}
+		ch <- prometheus.MustNewConstMetric(DeviceInfoDesc, prometheus.GaugeValue, 1, append(labels, device.Hostname, device.User, device.OS, device.ClientVersion, strings.Join(device.Tags, ","))...)
+		ch <- prometheus.MustNewConstMetric(DeviceAuthorizedDesc, prometheus.GaugeValue, boolToFloat(device.Authorized), labels...)
+		ch <- prometheus.MustNewConstMetric(DeviceUpdateAvailableDesc, prometheus.GaugeValue, boolToFloat(device.UpdateAvailable), labels...)
+		if !device.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(DeviceLastSeenDesc, prometheus.GaugeValue, float64(device.LastSeen.Unix()), labels...)
+		}
+		if !device.KeyExpiryDisabled && !device.Expires.IsZero() {
+			ch <- prometheus.MustNewConstMetric(DeviceKeyExpiryDesc, prometheus.GaugeValue, float64(device.Expires.Unix()), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(DeviceRoutesAdvertisedDesc, prometheus.GaugeValue, float64(len(device.AdvertisedRoutes)), labels...)
+		ch <- prometheus.MustNewConstMetric(DeviceRoutesEnabledDesc, prometheus.GaugeValue, float64(len(device.EnabledRoutes)), labels...)
+		// the routes of fields=all are those of the device routes endpoint, no call per device needed
+		for _, route := range device.AdvertisedRoutes {
+			if !slices.Contains(device.EnabledRoutes, route) {
+				ch <- prometheus.MustNewConstMetric(DeviceRouteUnapprovedDesc, prometheus.GaugeValue, 1, append(slices.Clone(labels), route)...)
+			}
+		}
+		if len(collector.PostureAttributes) > 0 {
+			if err := collector.scrapePosture(ctx, ch, device.ID, labels); err != nil {
+				slog.Error("error on posture attributes", "device", device.Name, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (collector *AdminAPICollector) scrapePosture(ctx context.Context, ch chan<- prometheus.Metric, deviceID string, labels []string) error {
+	attributes, err := collector.Client.GetPostureAttributes(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	for _, name := range collector.PostureAttributes {
+		attributeLabels := append(slices.Clone(labels), name)
+		switch value := attributes[name].(type) {
+		case bool:
+			ch <- prometheus.MustNewConstMetric(DevicePostureDesc, prometheus.GaugeValue, boolToFloat(value), attributeLabels...)
+		case float64:
+			ch <- prometheus.MustNewConstMetric(DevicePostureDesc, prometheus.GaugeValue, value, attributeLabels...)
+		case string:
+			ch <- prometheus.MustNewConstMetric(DevicePostureInfoDesc, prometheus.GaugeValue, 1, append(attributeLabels, value)...)
+		}
+	}
+	return nil
+}
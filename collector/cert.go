@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var CertExpiryDesc = prometheus.NewDesc("cert_expiry_timestamp_seconds", "When the provisioned MagicDNS HTTPS certificate expires.", []string{"domain"}, nil)
+
+// CertCollector exports the expiry of certificates provisioned by `tailscale cert` or serve.
+// It reads <domain>.crt files from Dir instead of LocalAPI, which would issue a cert if none exists.
+type CertCollector struct {
+	Dir string
+}
+
+func (collector *CertCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- CertExpiryDesc
+}
+
+func (collector *CertCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *CertCollector) scrape(ch chan<- prometheus.Metric) error {
+	paths, err := filepath.Glob(filepath.Join(collector.Dir, "*.crt"))
+	if err != nil {
+		return fmt.Errorf("error on list certs: %w", err)
+	}
+	for _, path := range paths {
+		cert, err := readCert(path)
+		if err != nil {
+			slog.Error("cert read failed", "err", err)
+			continue
+		}
+		domain := strings.TrimSuffix(filepath.Base(path), ".crt")
+		ch <- prometheus.MustNewConstMetric(CertExpiryDesc, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), domain)
+	}
+	return nil
+}
+
+// readCert returns the leaf, the first certificate of the PEM chain at path.
+func readCert(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error on read cert: %w", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("error on parse cert %s: no PEM certificate", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error on parse cert %s: %w", path, err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"log/slog"
+	"sort"
+)
+
+var (
+	DaemonCPUDesc       = prometheus.V2.NewDesc("daemon_cpu_seconds_total", "User and system CPU time spent by tailscaled.", prometheus.UnconstrainedLabels{}, nil, prometheus.WithUnit("seconds"))
+	DaemonResidentDesc  = prometheus.V2.NewDesc("daemon_resident_memory_bytes", "Resident memory of tailscaled.", prometheus.UnconstrainedLabels{}, nil, prometheus.WithUnit("bytes"))
+	DaemonOpenFDsDesc   = prometheus.NewDesc("daemon_open_fds", "Open file descriptors of tailscaled.", nil, nil)
+	DaemonMaxFDsDesc    = prometheus.NewDesc("daemon_max_fds", "File descriptor limit of tailscaled.", nil, nil)
+	DaemonStartTimeDesc = prometheus.NewDesc("daemon_start_time_seconds", "Start time of tailscaled since unix epoch.", nil, nil)
+)
+
+// DaemonCollector exports resource usage of the tailscaled process, found by name in procfs (Linux),
+// to correlate tunnel issues with daemon resource exhaustion. With several tailscaled, the lowest PID is used.
+type DaemonCollector struct {
+	// ProcPath is /proc, or the one of the host when running in a container
+	ProcPath string
+}
+
+func (collector *DaemonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- DaemonCPUDesc
+	ch <- DaemonResidentDesc
+	ch <- DaemonOpenFDsDesc
+	ch <- DaemonMaxFDsDesc
+	ch <- DaemonStartTimeDesc
+}
+
+func (collector *DaemonCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *DaemonCollector) scrape(ch chan<- prometheus.Metric) error {
+	proc, err := findProc(collector.ProcPath, "tailscaled")
+	if err != nil {
+		return err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return fmt.Errorf("error on read tailscaled stat: %w", err)
+	}
+	startTime, err := stat.StartTime()
+	if err != nil {
+		return fmt.Errorf("error on read tailscaled start time: %w", err)
+	}
+	openFDs, err := proc.FileDescriptorsLen()
+	if err != nil {
+		return fmt.Errorf("error on read tailscaled fds: %w", err)
+	}
+	limits, err := proc.Limits()
+	if err != nil {
+		return fmt.Errorf("error on read tailscaled limits: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(DaemonCPUDesc, prometheus.CounterValue, stat.CPUTime())
+	ch <- prometheus.MustNewConstMetric(DaemonResidentDesc, prometheus.GaugeValue, float64(stat.ResidentMemory()))
+	ch <- prometheus.MustNewConstMetric(DaemonOpenFDsDesc, prometheus.GaugeValue, float64(openFDs))
+	ch <- prometheus.MustNewConstMetric(DaemonMaxFDsDesc, prometheus.GaugeValue, float64(limits.OpenFiles))
+	ch <- prometheus.MustNewConstMetric(DaemonStartTimeDesc, prometheus.GaugeValue, startTime)
+	return nil
+}
+
+// findProc returns the process with the lowest PID named comm.
+func findProc(procPath string, comm string) (procfs.Proc, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return procfs.Proc{}, fmt.Errorf("error on open procfs: %w", err)
+	}
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return procfs.Proc{}, fmt.Errorf("error on list processes: %w", err)
+	}
+	sort.Sort(procs)
+	for _, proc := range procs {
+		if name, err := proc.Comm(); err == nil && name == comm {
+			return proc, nil
+		}
+	}
+	return procfs.Proc{}, fmt.Errorf("no %s process found in %s", comm, procPath)
+}
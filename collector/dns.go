@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"tailscale.com/client/local"
+	"time"
+)
+
+var (
+	DNSMagicDNSEnabledDesc = prometheus.NewDesc("dns_magicdns_enabled", "Whether MagicDNS is enabled in the tailnet.", nil, nil)
+	DNSResolversDesc       = prometheus.NewDesc("dns_resolvers", "Number of global DNS resolvers pushed by the tailnet.", nil, nil)
+	DNSRoutesDesc          = prometheus.NewDesc("dns_split_dns_routes", "Number of split DNS domains with their own resolvers.", nil, nil)
+	DNSSearchDomainsDesc   = prometheus.NewDesc("dns_search_domains", "Number of search domains pushed by the tailnet.", nil, nil)
+)
+
+// DNSCollector exports the tailnet DNS configuration seen by this node, read from LocalAPI,
+// to notice resolvers or search domains vanishing. accept-dns itself is exported by the prefs collector.
+type DNSCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *DNSCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- DNSMagicDNSEnabledDesc
+	ch <- DNSResolversDesc
+	ch <- DNSRoutesDesc
+	ch <- DNSSearchDomainsDesc
+}
+
+func (collector *DNSCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *DNSCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	status, err := collector.LocalClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get status: %w", err)
+	}
+	if status.CurrentTailnet != nil {
+		ch <- prometheus.MustNewConstMetric(DNSMagicDNSEnabledDesc, prometheus.GaugeValue, boolToFloat(status.CurrentTailnet.MagicDNSEnabled))
+	}
+	dnsConfig, err := collector.LocalClient.DNSConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get dns config: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(DNSResolversDesc, prometheus.GaugeValue, float64(len(dnsConfig.Resolvers)))
+	ch <- prometheus.MustNewConstMetric(DNSRoutesDesc, prometheus.GaugeValue, float64(len(dnsConfig.Routes)))
+	ch <- prometheus.MustNewConstMetric(DNSSearchDomainsDesc, prometheus.GaugeValue, float64(len(dnsConfig.Domains)))
+	return nil
+}
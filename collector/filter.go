@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"tailscale-exporter/tailscaleclient"
+)
+
+const mullvadDNSSuffix = ".mullvad.ts.net."
+
+// PeerFilter decides which peers are exported. Empty filter exports everything.
+type PeerFilter struct {
+	IncludeTags    []string `yaml:"include_tags"`
+	ExcludeTags    []string `yaml:"exclude_tags"`
+	OnlyOnline     bool     `yaml:"only_online"`
+	ExcludeMullvad bool     `yaml:"exclude_mullvad"`
+	// IncludeRegex and ExcludeRegex are anchored and matched against the host name and the DNS name without trailing dot
+	IncludeRegex string `yaml:"include_regex"`
+	ExcludeRegex string `yaml:"exclude_regex"`
+
+	includeRe *regexp.Regexp
+	excludeRe *regexp.Regexp
+}
+
+// Compile prepares IncludeRegex and ExcludeRegex, call it before Match.
+func (f *PeerFilter) Compile() error {
+	var err error
+	if f.includeRe, err = compileAnchored(f.IncludeRegex); err != nil {
+		return fmt.Errorf("invalid peer include regex: %w", err)
+	}
+	if f.excludeRe, err = compileAnchored(f.ExcludeRegex); err != nil {
+		return fmt.Errorf("invalid peer exclude regex: %w", err)
+	}
+	return nil
+}
+
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (f *PeerFilter) Match(peer *tailscaleclient.Peer) bool {
+	if f.OnlyOnline && !peer.Online {
+		return false
+	}
+	if f.ExcludeMullvad && isMullvadPeer(peer) {
+		return false
+	}
+	if len(f.IncludeTags) > 0 && !hasAnyTag(peer, f.IncludeTags) {
+		return false
+	}
+	if len(f.ExcludeTags) > 0 && hasAnyTag(peer, f.ExcludeTags) {
+		return false
+	}
+	if f.includeRe != nil && !matchesName(peer, f.includeRe) {
+		return false
+	}
+	if f.excludeRe != nil && matchesName(peer, f.excludeRe) {
+		return false
+	}
+	return true
+}
+
+func matchesName(peer *tailscaleclient.Peer, re *regexp.Regexp) bool {
+	return re.MatchString(peer.HostName) || re.MatchString(strings.TrimSuffix(peer.DNSName, "."))
+}
+
+func isMullvadPeer(peer *tailscaleclient.Peer) bool {
+	return strings.HasSuffix(peer.DNSName, mullvadDNSSuffix)
+}
+
+func hasAnyTag(peer *tailscaleclient.Peer, tags []string) bool {
+	for _, tag := range peer.Tags {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+var (
+	ForwardingRequiredDesc = prometheus.NewDesc("forwarding_required", "Whether this node forwards traffic for others: it has approved subnet routes or is an approved exit node.", nil, nil)
+	ForwardingCheckDesc    = prometheus.NewDesc("forwarding_check_ok", "Whether an OS prerequisite of subnet routers and exit nodes passes: ipv4_forward and ipv6_forward sysctls, udp_gro from the tailscaled health warnings.", []string{"check"}, nil)
+)
+
+// ForwardingCollector checks the OS prerequisites of subnet routers and exit nodes, which break routing silently
+// after kernel or sysctl changes. The sysctls are read from procfs (Linux) in the network namespace of the exporter.
+type ForwardingCollector struct {
+	Provider tailscaleclient.StatusProvider
+	Timeout  time.Duration
+	// ProcPath is /proc, or the one of the host when running in a container with host networking
+	ProcPath string
+}
+
+func (collector *ForwardingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ForwardingRequiredDesc
+	ch <- ForwardingCheckDesc
+}
+
+func (collector *ForwardingCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *ForwardingCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	status, err := collector.Provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	required := status.Self.ExitNodeOption || len(subnetRoutes(status.Self.AllowedIPs, status.Self.TailscaleIPs)) > 0
+	ch <- prometheus.MustNewConstMetric(ForwardingRequiredDesc, prometheus.GaugeValue, boolToFloat(required))
+	// missing where there is no procfs or IPv6 is disabled
+	for check, path := range map[string]string{"ipv4_forward": "sys/net/ipv4/ip_forward", "ipv6_forward": "sys/net/ipv6/conf/all/forwarding"} {
+		raw, err := os.ReadFile(filepath.Join(collector.ProcPath, path))
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(ForwardingCheckDesc, prometheus.GaugeValue, boolToFloat(strings.TrimSpace(string(raw)) == "1"), check)
+	}
+	groOK := true
+	for _, message := range status.Health {
+		if strings.Contains(message, "UDP GRO forwarding is suboptimally configured") {
+			groOK = false
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ForwardingCheckDesc, prometheus.GaugeValue, boolToFloat(groOK), "udp_gro")
+	return nil
+}
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HeadscaleClient talks to the REST API of a self-hosted Headscale control server.
+type HeadscaleClient struct {
+	BaseURL string
+	APIKey  string
+}
+
+type HeadscaleUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type HeadscaleNode struct {
+	ID              string        `json:"id"`
+	Name            string        `json:"name"`
+	GivenName       string        `json:"givenName"`
+	User            HeadscaleUser `json:"user"`
+	IPAddresses     []string      `json:"ipAddresses"`
+	Online          bool          `json:"online"`
+	LastSeen        *time.Time    `json:"lastSeen"`
+	Expiry          *time.Time    `json:"expiry"`
+	CreatedAt       *time.Time    `json:"createdAt"`
+	ValidTags       []string      `json:"validTags"`
+	ForcedTags      []string      `json:"forcedTags"`
+	AvailableRoutes []string      `json:"availableRoutes"`
+	ApprovedRoutes  []string      `json:"approvedRoutes"`
+}
+
+type HeadscalePreAuthKey struct {
+	ID         string     `json:"id"`
+	Reusable   bool       `json:"reusable"`
+	Ephemeral  bool       `json:"ephemeral"`
+	Used       bool       `json:"used"`
+	Expiration *time.Time `json:"expiration"`
+}
+
+func (client *HeadscaleClient) ListNodes(ctx context.Context) ([]HeadscaleNode, error) {
+	response := struct {
+		Nodes []HeadscaleNode `json:"nodes"`
+	}{}
+	if err := client.get(ctx, "/api/v1/node", &response); err != nil {
+		return nil, fmt.Errorf("error on headscale nodes list: %w", err)
+	}
+	return response.Nodes, nil
+}
+
+func (client *HeadscaleClient) ListUsers(ctx context.Context) ([]HeadscaleUser, error) {
+	response := struct {
+		Users []HeadscaleUser `json:"users"`
+	}{}
+	if err := client.get(ctx, "/api/v1/user", &response); err != nil {
+		return nil, fmt.Errorf("error on headscale users list: %w", err)
+	}
+	return response.Users, nil
+}
+
+func (client *HeadscaleClient) ListPreAuthKeys(ctx context.Context, userID string) ([]HeadscalePreAuthKey, error) {
+	response := struct {
+		PreAuthKeys []HeadscalePreAuthKey `json:"preAuthKeys"`
+	}{}
+	if err := client.get(ctx, "/api/v1/preauthkey?user="+url.QueryEscape(userID), &response); err != nil {
+		return nil, fmt.Errorf("error on headscale preauth keys list: %w", err)
+	}
+	return response.PreAuthKeys, nil
+}
+
+func (client *HeadscaleClient) get(ctx context.Context, path string, v any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(client.BaseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+client.APIKey)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", response.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+var headscaleNodeLabels = []string{"node_id", "given_name", "user"}
+var (
+	HeadscaleUpDesc              = prometheus.NewDesc("headscale_up", "Whether the last Headscale API collection succeeded.", nil, nil)
+	HeadscaleUsersDesc           = prometheus.NewDesc("headscale_users", "Number of Headscale users.", nil, nil)
+	HeadscaleNodeInfoDesc        = prometheus.NewDesc("headscale_node_info", "Headscale node metadata.", append(slices.Clone(headscaleNodeLabels), "name", "ip", "tags"), nil)
+	HeadscaleNodeOnlineDesc      = prometheus.NewDesc("headscale_node_online", "Whether the node is connected to Headscale.", headscaleNodeLabels, nil)
+	HeadscaleNodeLastSeenDesc    = prometheus.NewDesc("headscale_node_last_seen_timestamp_seconds", "When the node was last seen by Headscale.", headscaleNodeLabels, nil)
+	HeadscaleNodeExpiryDesc      = prometheus.NewDesc("headscale_node_expiry_timestamp_seconds", "When the node key expires.", headscaleNodeLabels, nil)
+	HeadscaleNodeRoutesAvailDesc = prometheus.NewDesc("headscale_node_routes_available", "Number of subnet routes announced by the node.", headscaleNodeLabels, nil)
+	HeadscaleNodeRoutesApprDesc  = prometheus.NewDesc("headscale_node_routes_approved", "Number of subnet routes approved for the node.", headscaleNodeLabels, nil)
+	HeadscalePreAuthKeyDesc      = prometheus.NewDesc("headscale_preauth_key_expiry_timestamp_seconds", "When the preauth key expires.", []string{"user", "key_id", "reusable", "ephemeral", "used"}, nil)
+)
+
+// HeadscaleCollector exports nodes, users and preauth keys of a Headscale server.
+type HeadscaleCollector struct {
+	Client  *HeadscaleClient
+	Timeout time.Duration
+}
+
+func (collector *HeadscaleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- HeadscaleUpDesc
+	ch <- HeadscaleUsersDesc
+	ch <- HeadscaleNodeInfoDesc
+	ch <- HeadscaleNodeOnlineDesc
+	ch <- HeadscaleNodeLastSeenDesc
+	ch <- HeadscaleNodeExpiryDesc
+	ch <- HeadscaleNodeRoutesAvailDesc
+	ch <- HeadscaleNodeRoutesApprDesc
+	ch <- HeadscalePreAuthKeyDesc
+}
+
+func (collector *HeadscaleCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *HeadscaleCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	err := collector.collect(ctx, ch)
+	ch <- prometheus.MustNewConstMetric(HeadscaleUpDesc, prometheus.GaugeValue, boolToFloat(err == nil))
+	return err
+}
+
+func (collector *HeadscaleCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := collector.Client.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		labels := []string{node.ID, node.GivenName, node.User.Name}
+		ip := ""
+		if len(node.IPAddresses) > 0 {
+			ip = node.IPAddresses[0]
+		}
+		tags := slices.Concat(node.ForcedTags, node.ValidTags)
+		ch <- prometheus.MustNewConstMetric(HeadscaleNodeInfoDesc, prometheus.GaugeValue, 1, append(labels, node.Name, ip, strings.Join(tags, ","))...)
+		ch <- prometheus.MustNewConstMetric(HeadscaleNodeOnlineDesc, prometheus.GaugeValue, boolToFloat(node.Online), labels...)
+		if node.LastSeen != nil && !node.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(HeadscaleNodeLastSeenDesc, prometheus.GaugeValue, float64(node.LastSeen.Unix()), labels...)
+		}
+		if node.Expiry != nil && !node.Expiry.IsZero() {
+			ch <- prometheus.MustNewConstMetric(HeadscaleNodeExpiryDesc, prometheus.GaugeValue, float64(node.Expiry.Unix()), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(HeadscaleNodeRoutesAvailDesc, prometheus.GaugeValue, float64(len(node.AvailableRoutes)), labels...)
+		ch <- prometheus.MustNewConstMetric(HeadscaleNodeRoutesApprDesc, prometheus.GaugeValue, float64(len(node.ApprovedRoutes)), labels...)
+	}
+
+	users, err := collector.Client.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(HeadscaleUsersDesc, prometheus.GaugeValue, float64(len(users)))
+	for _, user := range users {
+		keys, err := collector.Client.ListPreAuthKeys(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key.Expiration == nil || key.Expiration.IsZero() {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(HeadscalePreAuthKeyDesc, prometheus.GaugeValue, float64(key.Expiration.Unix()),
+				user.Name, key.ID, fmt.Sprint(key.Reusable), fmt.Sprint(key.Ephemeral), fmt.Sprint(key.Used))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type AdminAPIKey struct {
+	ID           string    `json:"id"`
+	KeyType      string    `json:"keyType"`
+	Description  string    `json:"description"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	Revoked      time.Time `json:"revoked"`
+	Invalid      bool      `json:"invalid"`
+	Capabilities struct {
+		Devices struct {
+			Create struct {
+				Reusable      bool     `json:"reusable"`
+				Ephemeral     bool     `json:"ephemeral"`
+				Preauthorized bool     `json:"preauthorized"`
+				Tags          []string `json:"tags"`
+			} `json:"create"`
+		} `json:"devices"`
+	} `json:"capabilities"`
+}
+
+// ListKeys returns the keys visible to the credentials. The list only has IDs, every key is fetched on its own.
+func (client *AdminAPIClient) ListKeys(ctx context.Context) ([]AdminAPIKey, error) {
+	response := struct {
+		Keys []AdminAPIKey `json:"keys"`
+	}{}
+	if err := client.get(ctx, "/keys", &response); err != nil {
+		return nil, err
+	}
+	keys := make([]AdminAPIKey, 0, len(response.Keys))
+	for _, listed := range response.Keys {
+		key := AdminAPIKey{}
+		if err := client.get(ctx, "/keys/"+url.PathEscape(listed.ID), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+var keyLabels = []string{"key_id", "description"}
+var (
+	KeyInfoDesc          = prometheus.NewDesc("key_info", "Auth and API key metadata from the Admin API.", append(slices.Clone(keyLabels), "key_type", "reusable", "ephemeral", "preauthorized"), nil)
+	KeyCreatedDesc       = prometheus.NewDesc("key_created_timestamp_seconds", "When the key was created.", keyLabels, nil)
+	KeyExpiryDesc        = prometheus.NewDesc("key_expiry_timestamp_seconds", "When the key expires.", keyLabels, nil)
+	KeysExpiringSoonDesc = prometheus.NewDesc("keys_expiring_soon", "Number of valid keys expiring within the window.", []string{"within"}, nil)
+)
+
+// KeysCollector exports the expiry of the auth keys and API keys of the tailnet, revoked and expired keys are skipped.
+type KeysCollector struct {
+	Client  *AdminAPIClient
+	Timeout time.Duration
+	// ExpiryWindow is how far ahead keys_expiring_soon looks
+	ExpiryWindow time.Duration
+}
+
+func (collector *KeysCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- KeyInfoDesc
+	ch <- KeyCreatedDesc
+	ch <- KeyExpiryDesc
+	ch <- KeysExpiringSoonDesc
+}
+
+func (collector *KeysCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *KeysCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	keys, err := collector.Client.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expiringSoon := 0
+	for _, key := range keys {
+		if key.Invalid || !key.Revoked.IsZero() || (!key.Expires.IsZero() && key.Expires.Before(now)) {
+			continue
+		}
+		keyType := key.KeyType
+		if keyType == "" {
+			keyType = "auth"
+		}
+		create := key.Capabilities.Devices.Create
+		labels := []string{key.ID, key.Description}
+		ch <- prometheus.MustNewConstMetric(KeyInfoDesc, prometheus.GaugeValue, 1, append(labels, keyType, strconv.FormatBool(create.Reusable), strconv.FormatBool(create.Ephemeral), strconv.FormatBool(create.Preauthorized))...)
+		if !key.Created.IsZero() {
+			ch <- prometheus.MustNewConstMetric(KeyCreatedDesc, prometheus.GaugeValue, float64(key.Created.Unix()), labels...)
+		}
+		if !key.Expires.IsZero() {
+			ch <- prometheus.MustNewConstMetric(KeyExpiryDesc, prometheus.GaugeValue, float64(key.Expires.Unix()), labels...)
+			if key.Expires.Before(now.Add(collector.ExpiryWindow)) {
+				expiringSoon++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(KeysExpiringSoonDesc, prometheus.GaugeValue, float64(expiringSoon), shortDuration(collector.ExpiryWindow))
+	return nil
+}
@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"strconv"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+var (
+	NetcheckUDPDesc           = prometheus.NewDesc("netcheck_udp", "Whether a UDP STUN round trip completed.", nil, nil)
+	NetcheckIPv4Desc          = prometheus.NewDesc("netcheck_ipv4", "Whether an IPv4 STUN round trip completed.", nil, nil)
+	NetcheckIPv6Desc          = prometheus.NewDesc("netcheck_ipv6", "Whether an IPv6 STUN round trip completed.", nil, nil)
+	NetcheckMappingVariesDesc = prometheus.NewDesc("netcheck_mapping_varies_by_dest_ip", "Whether the NAT mapping depends on the destination (hard NAT).", nil, nil)
+	NetcheckPortMapperDesc    = prometheus.NewDesc("netcheck_portmapper_available", "Whether a port mapping protocol is available on the LAN.", []string{"protocol"}, nil)
+	NetcheckPreferredDERPDesc = prometheus.NewDesc("netcheck_preferred_derp_region", "ID of the preferred DERP region.", nil, nil)
+	NetcheckDERPLatencyDesc   = prometheus.NewDesc("netcheck_derp_latency_seconds", "Latency to DERP region.", []string{"region_id", "family"}, nil)
+	NetcheckDERPRegionsDesc   = prometheus.NewDesc("netcheck_derp_regions", "Number of DERP regions of the DERP map that answered in the last netcheck run.", nil, nil)
+	NetcheckSuccessDesc       = prometheus.NewDesc("netcheck_success", "Whether the last netcheck run succeeded.", nil, nil)
+	NetcheckTimestampDesc     = prometheus.NewDesc("netcheck_last_success_timestamp_seconds", "Time of the last successful netcheck run.", nil, nil)
+)
+
+// NetcheckCollector runs netcheck in background on interval and exports the last report.
+type NetcheckCollector struct {
+	CLI      tailscaleclient.CLI
+	Interval time.Duration
+	Timeout  time.Duration
+
+	mu          sync.Mutex
+	report      *tailscaleclient.NetcheckReport
+	lastSuccess time.Time
+	lastErr     error
+}
+
+func (collector *NetcheckCollector) Run(ctx context.Context) {
+	for {
+		if err := collector.Update(ctx); err != nil {
+			slog.Error("netcheck failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(collector.Interval):
+		}
+	}
+}
+
+// Update runs netcheck once and stores the report.
+func (collector *NetcheckCollector) Update(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, collector.Timeout)
+	defer cancel()
+	report, err := collector.CLI.Netcheck(ctx)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.lastErr = err
+	if err == nil {
+		collector.report = report
+		collector.lastSuccess = time.Now()
+	}
+	return err
+}
+
+func (collector *NetcheckCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- NetcheckUDPDesc
+	ch <- NetcheckIPv4Desc
+	ch <- NetcheckIPv6Desc
+	ch <- NetcheckMappingVariesDesc
+	ch <- NetcheckPortMapperDesc
+	ch <- NetcheckPreferredDERPDesc
+	ch <- NetcheckDERPLatencyDesc
+	ch <- NetcheckDERPRegionsDesc
+	ch <- NetcheckSuccessDesc
+	ch <- NetcheckTimestampDesc
+}
+
+func (collector *NetcheckCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.report == nil && collector.lastErr == nil {
+		// first run is not finished yet
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(NetcheckSuccessDesc, prometheus.GaugeValue, boolToFloat(collector.lastErr == nil))
+	report := collector.report
+	if report == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(NetcheckTimestampDesc, prometheus.GaugeValue, float64(collector.lastSuccess.Unix()))
+	ch <- prometheus.MustNewConstMetric(NetcheckUDPDesc, prometheus.GaugeValue, boolToFloat(report.UDP))
+	ch <- prometheus.MustNewConstMetric(NetcheckIPv4Desc, prometheus.GaugeValue, boolToFloat(report.IPv4))
+	ch <- prometheus.MustNewConstMetric(NetcheckIPv6Desc, prometheus.GaugeValue, boolToFloat(report.IPv6))
+	if report.MappingVariesByDestIP != nil {
+		ch <- prometheus.MustNewConstMetric(NetcheckMappingVariesDesc, prometheus.GaugeValue, boolToFloat(*report.MappingVariesByDestIP))
+	}
+	for protocol, available := range map[string]*bool{"upnp": report.UPnP, "pmp": report.PMP, "pcp": report.PCP} {
+		if available != nil {
+			ch <- prometheus.MustNewConstMetric(NetcheckPortMapperDesc, prometheus.GaugeValue, boolToFloat(*available), protocol)
+		}
+	}
+	if report.PreferredDERP != 0 {
+		ch <- prometheus.MustNewConstMetric(NetcheckPreferredDERPDesc, prometheus.GaugeValue, float64(report.PreferredDERP))
+	}
+	ch <- prometheus.MustNewConstMetric(NetcheckDERPRegionsDesc, prometheus.GaugeValue, float64(len(report.RegionLatency)))
+	for family, latencies := range map[string]map[int]time.Duration{"any": report.RegionLatency, "ipv4": report.RegionV4Latency, "ipv6": report.RegionV6Latency} {
+		for regionID, latency := range latencies {
+			ch <- prometheus.MustNewConstMetric(NetcheckDERPLatencyDesc, prometheus.GaugeValue, latency.Seconds(), strconv.Itoa(regionID), family)
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,518 @@
+// Package collector holds the Prometheus collectors of the exporter. Metric names omit the namespace,
+// the registerer adds it.
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// PeerCollector exports traffic, connectivity and metadata of the peers of one tailscaled.
+type PeerCollector struct {
+	Provider tailscaleclient.StatusProvider
+	Timeout  time.Duration
+	// RouteInfo adds one info series per subnet route
+	RouteInfo bool
+	// Accumulator, if set, exports byte counters adjusted for resets
+	Accumulator *CounterAccumulator
+	// KeyExpiryWindow is how far ahead peers_key_expiring_soon looks
+	KeyExpiryWindow time.Duration
+	// AggregateOnly skips the series per peer and per user, only totals over the exported peers are left
+	AggregateOnly bool
+	// UserTraffic exports the byte counters of the exported peers summed per owner, also with AggregateOnly
+	UserTraffic bool
+	// TagTraffic exports them summed per tag, a peer counts to each of its tags, also with AggregateOnly
+	TagTraffic bool
+	// GracePeriod keeps exporting peers gone from the netmap with their last values and peer_present 0 for this long,
+	// so departures and flapping peers do not make their series vanish and reappear
+	GracePeriod time.Duration
+	// RateInterval exports peer_rx_bytes_rate and peer_tx_bytes_rate, computed from samples at least this far apart, 0 disables them
+	RateInterval time.Duration
+
+	mu         sync.RWMutex
+	peerFilter PeerFilter
+
+	countersMu sync.Mutex
+	counters   map[string]peerCounters
+	keys       map[string]peerKey
+	seen       map[string]seenPeer
+	rates      map[string]peerRate
+}
+
+// peerRate is the last byte counter sample of a peer and the rates computed up to it.
+type peerRate struct {
+	rx, tx         int
+	at             time.Time
+	rxRate, txRate float64
+	computed       bool
+}
+
+// seenPeer is the last netmap entry of a peer and when it was in the netmap.
+type seenPeer struct {
+	peer     tailscaleclient.Peer
+	lastSeen time.Time
+}
+
+// peerCounters remembers since when the byte counters of a peer count, for _created.
+type peerCounters struct {
+	created time.Time
+	rx, tx  int
+}
+
+// peerKey remembers the last node key of a peer and how often the exporter saw it change.
+type peerKey struct {
+	publicKey string
+	changes   int
+}
+
+// SetPeerFilter replaces the peer filter. An invalid regex, rejected by config validation before, is logged and ignored.
+func (collector *PeerCollector) SetPeerFilter(filter PeerFilter) {
+	if err := filter.Compile(); err != nil {
+		slog.Error("error on compile peer filter", "err", err)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.peerFilter = filter
+}
+
+var dynLabels = []string{"id", "name", "given_name", "ip", "peer_name", "peer_given_name", "peer_ip", "peer_user_id"}
+var PeerRxDesc = prometheus.NewDesc("peer_rx", "", dynLabels, nil)
+var PeerTxDesc = prometheus.NewDesc("peer_tx", "", dynLabels, nil)
+
+// peer_rx and peer_tx lack the _total and unit suffixes, OpenMetrics scrapers see them as unknown
+var PeerRxBytesDesc = prometheus.V2.NewDesc("peer_rx_bytes_total", "Bytes received from the peer, like peer_rx. Created is when the exporter first saw the counter or its last reset.", prometheus.UnconstrainedLabels(dynLabels), nil, prometheus.WithUnit("bytes"))
+var PeerTxBytesDesc = prometheus.V2.NewDesc("peer_tx_bytes_total", "Bytes sent to the peer, like peer_tx. Created is when the exporter first saw the counter or its last reset.", prometheus.UnconstrainedLabels(dynLabels), nil, prometheus.WithUnit("bytes"))
+var PeerDirectDesc = prometheus.NewDesc("peer_direct_connection", "Whether the peer is reached directly (1) or through the DERP relay (0).", slices.Concat(dynLabels, []string{"relay"}), nil)
+var PeerEndpointsDesc = prometheus.NewDesc("peer_endpoints", "Number of endpoints the peer advertises: UDP addresses (source=addrs) and PeerAPI URLs (source=peerapi). Zero addrs usually means a hard NAT.", slices.Concat(dynLabels, []string{"source"}), nil)
+var UserInfoDesc = prometheus.NewDesc("user_info", "Tailnet user, always 1. Join on peer_user_id.", []string{"user_id", "login_name", "display_name"}, nil)
+var LoginRequiredDesc = prometheus.NewDesc("login_required", "Whether tailscaled waits for an interactive login (NeedsLogin or a pending AuthURL), e.g. after the node key expired.", nil, nil)
+var HealthWarningsDesc = prometheus.NewDesc("health_warnings", "Number of tailscaled health warnings.", nil, nil)
+var HealthWarningInfoDesc = prometheus.NewDesc("health_warning_info", "Current tailscaled health warning, always 1.", []string{"message"}, nil)
+var selfLabels = dynLabels[:4]
+var SelfAdvertisedRoutesDesc = prometheus.NewDesc("self_advertised_routes", "Number of subnet routes of this node in the netmap.", selfLabels, nil)
+var SelfRouteInfoDesc = prometheus.NewDesc("self_route_info", "Subnet route of this node, always 1.", slices.Concat(selfLabels, []string{"prefix"}), nil)
+var PeerCreatedDesc = prometheus.NewDesc("peer_created_timestamp_seconds", "When the peer node was added to the tailnet.", dynLabels, nil)
+var PeerAllowedIPsDesc = prometheus.NewDesc("peer_allowed_ips", "Number of prefixes routed to the peer, its own addresses included.", dynLabels, nil)
+var PeerRouteInfoDesc = prometheus.NewDesc("peer_route_info", "Subnet route of the peer, always 1.", slices.Concat(dynLabels, []string{"prefix"}), nil)
+var ExitNodeInUseDesc = prometheus.NewDesc("exit_node_in_use", "Whether traffic is routed through an exit node, labeled with that peer.", slices.Concat(selfLabels, []string{"exit_node_id", "exit_node_name", "exit_node_ip"}), nil)
+var ExitNodeOfferedDesc = prometheus.NewDesc("exit_node_offered", "Whether this node is an approved exit node for others.", selfLabels, nil)
+var ClientUpdateAvailableDesc = prometheus.NewDesc("client_update_available", "Whether a newer Tailscale client is available for this node, labeled with the running and latest versions.", slices.Concat(selfLabels, []string{"version", "latest_version"}), nil)
+var ClientUrgentSecurityUpdateDesc = prometheus.NewDesc("client_urgent_security_update", "Whether this node misses an important security update.", selfLabels, nil)
+var SelfHomeDERPDesc = prometheus.NewDesc("self_home_derp_region", "Home DERP region of this node, always 1. Empty region until a DERP connection is up.", slices.Concat(selfLabels, []string{"region"}), nil)
+var NetmapPeersDesc = prometheus.NewDesc("netmap_peers", "Number of peers in the netmap, regardless of peer filters.", nil, nil)
+var NetmapUsersDesc = prometheus.NewDesc("netmap_users", "Number of distinct users in the netmap.", nil, nil)
+var SelfInitializedDesc = prometheus.NewDesc("self_initialized", "Whether this node is known to a tailscaled component: network_map, magicsock or engine. Zeros after a restart mean a partial initialization.", slices.Concat(selfLabels, []string{"component"}), nil)
+var PeersTotalDesc = prometheus.NewDesc("peers_total", "Number of exported peers.", nil, nil)
+var PeersOnlineDesc = prometheus.NewDesc("peers_online", "Number of exported peers connected to the control plane.", nil, nil)
+var PeersKeyExpiredDesc = prometheus.NewDesc("peers_key_expired", "Number of exported peers whose node key expired.", nil, nil)
+var PeersKeyExpiringSoonDesc = prometheus.NewDesc("peers_key_expiring_soon", "Number of exported peers whose node key expires within the window, expired ones not included.", []string{"within"}, nil)
+var PeersByOSDesc = prometheus.NewDesc("peers_by_os", "Number of exported peers per operating system.", []string{"os"}, nil)
+var PeersByUserDesc = prometheus.NewDesc("peers_by_user", "Number of exported peers per owner login name.", []string{"user"}, nil)
+var PeersRxBytesDesc = prometheus.V2.NewDesc("peers_rx_bytes_total", "Bytes received from the exported peers, the sum of peer_rx. Drops when a peer goes away.", prometheus.UnconstrainedLabels(nil), nil, prometheus.WithUnit("bytes"))
+var PeersTxBytesDesc = prometheus.V2.NewDesc("peers_tx_bytes_total", "Bytes sent to the exported peers, the sum of peer_tx. Drops when a peer goes away.", prometheus.UnconstrainedLabels(nil), nil, prometheus.WithUnit("bytes"))
+var UserRxBytesDesc = prometheus.V2.NewDesc("user_rx_bytes_total", "Bytes received from the exported peers of the user. Drops when a peer goes away.", prometheus.UnconstrainedLabels([]string{"login_name"}), nil, prometheus.WithUnit("bytes"))
+var UserTxBytesDesc = prometheus.V2.NewDesc("user_tx_bytes_total", "Bytes sent to the exported peers of the user. Drops when a peer goes away.", prometheus.UnconstrainedLabels([]string{"login_name"}), nil, prometheus.WithUnit("bytes"))
+var TagRxBytesDesc = prometheus.V2.NewDesc("tag_rx_bytes_total", "Bytes received from the exported peers having the tag, empty for untagged peers. Drops when a peer goes away.", prometheus.UnconstrainedLabels([]string{"tag"}), nil, prometheus.WithUnit("bytes"))
+var TagTxBytesDesc = prometheus.V2.NewDesc("tag_tx_bytes_total", "Bytes sent to the exported peers having the tag, empty for untagged peers. Drops when a peer goes away.", prometheus.UnconstrainedLabels([]string{"tag"}), nil, prometheus.WithUnit("bytes"))
+var PeersDirectDesc = prometheus.NewDesc("peers_direct_connections", "Number of exported peers reached directly instead of through a DERP relay.", nil, nil)
+var PeerKeyInfoDesc = prometheus.NewDesc("peer_key_info", "Node ID and current node key of the peer, always 1.", slices.Concat(dynLabels, []string{"peer_id", "public_key"}), nil)
+var PeerKeyChangesDesc = prometheus.NewDesc("peer_key_changes_total", "Number of node key changes of the peer seen by the exporter. Expected on key expiry, otherwise the node may have been re-enrolled or impersonated.", dynLabels, nil)
+var PeerPresentDesc = prometheus.NewDesc("peer_present", "Whether the peer is in the netmap (1) or gone and only kept for collector.peers.grace-period (0).", dynLabels, nil)
+var PeerRxBytesRateDesc = prometheus.NewDesc("peer_rx_bytes_rate", "Bytes per second received from the peer, computed by the exporter over collector.peers.rate-interval, for consumers without rate().", dynLabels, nil)
+var PeerTxBytesRateDesc = prometheus.NewDesc("peer_tx_bytes_rate", "Bytes per second sent to the peer, computed by the exporter over collector.peers.rate-interval, for consumers without rate().", dynLabels, nil)
+var PeerInfoDesc = prometheus.NewDesc("peer_info", "Peer metadata, always 1.", slices.Concat(dynLabels, []string{"os", "tags", "relay", "exit_node", "exit_node_option", "dns_name"}), nil)
+
+func (collector *PeerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- LoginRequiredDesc
+	ch <- HealthWarningsDesc
+	ch <- HealthWarningInfoDesc
+	ch <- SelfAdvertisedRoutesDesc
+	ch <- ExitNodeInUseDesc
+	ch <- ExitNodeOfferedDesc
+	ch <- ClientUpdateAvailableDesc
+	ch <- ClientUrgentSecurityUpdateDesc
+	ch <- SelfHomeDERPDesc
+	ch <- NetmapPeersDesc
+	ch <- NetmapUsersDesc
+	ch <- SelfInitializedDesc
+	ch <- PeersTotalDesc
+	ch <- PeersOnlineDesc
+	ch <- PeersKeyExpiredDesc
+	ch <- PeersKeyExpiringSoonDesc
+	ch <- PeersByOSDesc
+	if collector.RouteInfo {
+		ch <- SelfRouteInfoDesc
+	}
+	if collector.UserTraffic {
+		ch <- UserRxBytesDesc
+		ch <- UserTxBytesDesc
+	}
+	if collector.TagTraffic {
+		ch <- TagRxBytesDesc
+		ch <- TagTxBytesDesc
+	}
+	if collector.AggregateOnly {
+		ch <- PeersRxBytesDesc
+		ch <- PeersTxBytesDesc
+		ch <- PeersDirectDesc
+		return
+	}
+	ch <- PeerTxDesc
+	ch <- PeerRxDesc
+	ch <- PeerTxBytesDesc
+	ch <- PeerRxBytesDesc
+	ch <- PeerInfoDesc
+	ch <- UserInfoDesc
+	ch <- PeerDirectDesc
+	ch <- PeerEndpointsDesc
+	ch <- PeerAllowedIPsDesc
+	ch <- PeerCreatedDesc
+	ch <- PeerKeyInfoDesc
+	if collector.GracePeriod > 0 {
+		ch <- PeerPresentDesc
+	}
+	if collector.RateInterval > 0 {
+		ch <- PeerRxBytesRateDesc
+		ch <- PeerTxBytesRateDesc
+	}
+	ch <- PeerKeyChangesDesc
+	ch <- PeersByUserDesc
+	if collector.RouteInfo {
+		ch <- PeerRouteInfoDesc
+	}
+	if collector.Accumulator != nil {
+		ch <- PeerRxAdjustedDesc
+		ch <- PeerTxAdjustedDesc
+		ch <- PeerCounterResetsDesc
+	}
+}
+
+// Collect implements required collect function for all promehteus collectors
+func (collector *PeerCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *PeerCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	status, err := collector.Provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	collector.mu.RLock()
+	peerFilter := collector.peerFilter
+	collector.mu.RUnlock()
+	ch <- prometheus.MustNewConstMetric(LoginRequiredDesc, prometheus.GaugeValue, boolToFloat(status.BackendState == "NeedsLogin" || status.AuthURL != ""))
+	templateLabels := make([]string, len(dynLabels))
+	templateLabels[0] = status.Self.ID
+	templateLabels[1] = status.Self.HostName
+	templateLabels[2] = strings.Split(status.Self.DNSName, ".")[0]
+	templateLabels[3] = firstIP(status.Self.TailscaleIPs)
+	selfRoutes := subnetRoutes(status.Self.AllowedIPs, status.Self.TailscaleIPs)
+	ch <- prometheus.MustNewConstMetric(SelfAdvertisedRoutesDesc, prometheus.GaugeValue, float64(len(selfRoutes)), templateLabels[:4]...)
+	if collector.RouteInfo {
+		for _, route := range selfRoutes {
+			ch <- prometheus.MustNewConstMetric(SelfRouteInfoDesc, prometheus.GaugeValue, 1, append(slices.Clone(templateLabels[:4]), route)...)
+		}
+	}
+	exitNode := []string{"", "", ""}
+	for _, peer := range status.Peer {
+		// regardless of peer filters, e.g. excluded Mullvad nodes
+		if peer.ExitNode {
+			exitNode = []string{peer.ID, peer.HostName, firstIP(peer.TailscaleIPs)}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ExitNodeInUseDesc, prometheus.GaugeValue, boolToFloat(exitNode[0] != ""), slices.Concat(templateLabels[:4], exitNode)...)
+	ch <- prometheus.MustNewConstMetric(ExitNodeOfferedDesc, prometheus.GaugeValue, boolToFloat(status.Self.ExitNodeOption), templateLabels[:4]...)
+	ch <- prometheus.MustNewConstMetric(SelfHomeDERPDesc, prometheus.GaugeValue, 1, append(slices.Clone(templateLabels[:4]), status.Self.Relay)...)
+	ch <- prometheus.MustNewConstMetric(NetmapPeersDesc, prometheus.GaugeValue, float64(len(status.Peer)))
+	ch <- prometheus.MustNewConstMetric(NetmapUsersDesc, prometheus.GaugeValue, float64(len(status.User)))
+	ch <- prometheus.MustNewConstMetric(SelfInitializedDesc, prometheus.GaugeValue, boolToFloat(status.Self.InNetworkMap), append(slices.Clone(templateLabels[:4]), "network_map")...)
+	ch <- prometheus.MustNewConstMetric(SelfInitializedDesc, prometheus.GaugeValue, boolToFloat(status.Self.InMagicSock), append(slices.Clone(templateLabels[:4]), "magicsock")...)
+	ch <- prometheus.MustNewConstMetric(SelfInitializedDesc, prometheus.GaugeValue, boolToFloat(status.Self.InEngine), append(slices.Clone(templateLabels[:4]), "engine")...)
+	if clientVersion := status.ClientVersion; clientVersion != nil {
+		latest := clientVersion.LatestVersion
+		if clientVersion.RunningLatest {
+			latest = strings.Split(status.Version, "-")[0]
+		}
+		ch <- prometheus.MustNewConstMetric(ClientUpdateAvailableDesc, prometheus.GaugeValue, boolToFloat(!clientVersion.RunningLatest), append(slices.Clone(templateLabels[:4]), status.Version, latest)...)
+		ch <- prometheus.MustNewConstMetric(ClientUrgentSecurityUpdateDesc, prometheus.GaugeValue, boolToFloat(clientVersion.UrgentSecurityUpdate), templateLabels[:4]...)
+	}
+	peers, online, direct := 0, 0, 0
+	keyExpired, keyExpiringSoon := 0, 0
+	now := time.Now()
+	var rx, tx int
+	byOS, byUser := map[string]int{}, map[string]int{}
+	rxByUser, txByUser := map[string]int{}, map[string]int{}
+	rxByTag, txByTag := map[string]int{}, map[string]int{}
+	var departed map[string]bool
+	if collector.GracePeriod > 0 {
+		status, departed = collector.withDeparted(status, now)
+	}
+	created := collector.counterCreated(status, now)
+	keyChanges := collector.keyChanges(status)
+	var rates map[string]peerRate
+	if collector.RateInterval > 0 && !collector.AggregateOnly {
+		rates = collector.byteRates(status, now)
+	}
+	var accumulated map[string]AccumulatedCounters
+	if collector.Accumulator != nil && !collector.AggregateOnly {
+		accumulated = collector.Accumulator.Observe(status)
+	}
+	for _, peer := range status.Peer {
+		if !peerFilter.Match(&peer) {
+			continue
+		}
+		peers++
+		if peer.Online {
+			online++
+		}
+		if peer.CurAddr != "" {
+			direct++
+		}
+		// zero when key expiry is disabled for the peer
+		switch {
+		case peer.KeyExpiry.IsZero():
+		case !peer.KeyExpiry.After(now):
+			keyExpired++
+		case peer.KeyExpiry.Before(now.Add(collector.KeyExpiryWindow)):
+			keyExpiringSoon++
+		}
+		rx += peer.RxBytes
+		tx += peer.TxBytes
+		byOS[peer.OS]++
+		loginName := status.User[strconv.Itoa(peer.UserID)].LoginName
+		byUser[loginName]++
+		rxByUser[loginName] += peer.RxBytes
+		txByUser[loginName] += peer.TxBytes
+		tags := peer.Tags
+		if len(tags) == 0 {
+			tags = []string{""}
+		}
+		for _, tag := range tags {
+			rxByTag[tag] += peer.RxBytes
+			txByTag[tag] += peer.TxBytes
+		}
+		if collector.AggregateOnly {
+			continue
+		}
+		labels := slices.Clone(templateLabels)
+		labels[4] = peer.HostName
+		labels[5] = strings.Split(peer.DNSName, ".")[0]
+		labels[6] = firstIP(peer.TailscaleIPs)
+		labels[7] = strconv.Itoa(peer.UserID)
+
+		ch <- prometheus.MustNewConstMetric(PeerRxDesc, prometheus.CounterValue, float64(peer.RxBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(PeerTxDesc, prometheus.CounterValue, float64(peer.TxBytes), labels...)
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(PeerRxBytesDesc, prometheus.CounterValue, float64(peer.RxBytes), created[peer.ID], labels...)
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(PeerTxBytesDesc, prometheus.CounterValue, float64(peer.TxBytes), created[peer.ID], labels...)
+		if counters, ok := accumulated[peer.ID]; ok {
+			ch <- prometheus.MustNewConstMetric(PeerRxAdjustedDesc, prometheus.CounterValue, float64(counters.Rx), labels...)
+			ch <- prometheus.MustNewConstMetric(PeerTxAdjustedDesc, prometheus.CounterValue, float64(counters.Tx), labels...)
+			ch <- prometheus.MustNewConstMetric(PeerCounterResetsDesc, prometheus.CounterValue, float64(counters.Resets), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(PeerDirectDesc, prometheus.GaugeValue, boolToFloat(peer.CurAddr != ""), append(slices.Clone(labels), peer.Relay)...)
+		ch <- prometheus.MustNewConstMetric(PeerEndpointsDesc, prometheus.GaugeValue, float64(len(peer.Addrs)), append(slices.Clone(labels), "addrs")...)
+		ch <- prometheus.MustNewConstMetric(PeerEndpointsDesc, prometheus.GaugeValue, float64(len(peer.PeerAPIURL)), append(slices.Clone(labels), "peerapi")...)
+		ch <- prometheus.MustNewConstMetric(PeerAllowedIPsDesc, prometheus.GaugeValue, float64(len(peer.AllowedIPs)), labels...)
+		if !peer.Created.IsZero() {
+			ch <- prometheus.MustNewConstMetric(PeerCreatedDesc, prometheus.GaugeValue, float64(peer.Created.Unix()), labels...)
+		}
+		if rate, ok := rates[peer.ID]; ok && rate.computed {
+			ch <- prometheus.MustNewConstMetric(PeerRxBytesRateDesc, prometheus.GaugeValue, rate.rxRate, labels...)
+			ch <- prometheus.MustNewConstMetric(PeerTxBytesRateDesc, prometheus.GaugeValue, rate.txRate, labels...)
+		}
+		if collector.GracePeriod > 0 {
+			ch <- prometheus.MustNewConstMetric(PeerPresentDesc, prometheus.GaugeValue, boolToFloat(!departed[peer.ID]), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(PeerKeyInfoDesc, prometheus.GaugeValue, 1, append(slices.Clone(labels), peer.ID, peer.PublicKey)...)
+		ch <- prometheus.MustNewConstMetric(PeerKeyChangesDesc, prometheus.CounterValue, float64(keyChanges[peer.ID]), labels...)
+		if collector.RouteInfo {
+			for _, route := range subnetRoutes(peer.AllowedIPs, peer.TailscaleIPs) {
+				ch <- prometheus.MustNewConstMetric(PeerRouteInfoDesc, prometheus.GaugeValue, 1, append(slices.Clone(labels), route)...)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(PeerInfoDesc, prometheus.GaugeValue, 1, append(labels,
+			peer.OS, strings.Join(peer.Tags, ","), peer.Relay, strconv.FormatBool(peer.ExitNode), strconv.FormatBool(peer.ExitNodeOption), peer.DNSName)...)
+	}
+	ch <- prometheus.MustNewConstMetric(PeersTotalDesc, prometheus.GaugeValue, float64(peers))
+	ch <- prometheus.MustNewConstMetric(PeersOnlineDesc, prometheus.GaugeValue, float64(online))
+	ch <- prometheus.MustNewConstMetric(PeersKeyExpiredDesc, prometheus.GaugeValue, float64(keyExpired))
+	ch <- prometheus.MustNewConstMetric(PeersKeyExpiringSoonDesc, prometheus.GaugeValue, float64(keyExpiringSoon), shortDuration(collector.KeyExpiryWindow))
+	for peerOS, count := range byOS {
+		ch <- prometheus.MustNewConstMetric(PeersByOSDesc, prometheus.GaugeValue, float64(count), peerOS)
+	}
+	ch <- prometheus.MustNewConstMetric(HealthWarningsDesc, prometheus.GaugeValue, float64(len(status.Health)))
+	for _, message := range slices.Compact(slices.Sorted(slices.Values(status.Health))) {
+		ch <- prometheus.MustNewConstMetric(HealthWarningInfoDesc, prometheus.GaugeValue, 1, message)
+	}
+	if collector.UserTraffic {
+		for user, bytes := range rxByUser {
+			ch <- prometheus.MustNewConstMetric(UserRxBytesDesc, prometheus.CounterValue, float64(bytes), user)
+		}
+		for user, bytes := range txByUser {
+			ch <- prometheus.MustNewConstMetric(UserTxBytesDesc, prometheus.CounterValue, float64(bytes), user)
+		}
+	}
+	if collector.TagTraffic {
+		for tag, bytes := range rxByTag {
+			ch <- prometheus.MustNewConstMetric(TagRxBytesDesc, prometheus.CounterValue, float64(bytes), tag)
+		}
+		for tag, bytes := range txByTag {
+			ch <- prometheus.MustNewConstMetric(TagTxBytesDesc, prometheus.CounterValue, float64(bytes), tag)
+		}
+	}
+	if collector.AggregateOnly {
+		ch <- prometheus.MustNewConstMetric(PeersRxBytesDesc, prometheus.CounterValue, float64(rx))
+		ch <- prometheus.MustNewConstMetric(PeersTxBytesDesc, prometheus.CounterValue, float64(tx))
+		ch <- prometheus.MustNewConstMetric(PeersDirectDesc, prometheus.GaugeValue, float64(direct))
+		return nil
+	}
+	for user, count := range byUser {
+		ch <- prometheus.MustNewConstMetric(PeersByUserDesc, prometheus.GaugeValue, float64(count), user)
+	}
+	for _, user := range status.User {
+		ch <- prometheus.MustNewConstMetric(UserInfoDesc, prometheus.GaugeValue, 1, strconv.Itoa(user.ID), user.LoginName, user.DisplayName)
+	}
+	return nil
+}
+
+// counterCreated returns the created timestamp of the byte counters per peer ID. tailscaled does not
+// tell when they started, so it is the first time seen, moved to now when either counter went down.
+func (collector *PeerCollector) counterCreated(status *tailscaleclient.Status, now time.Time) map[string]time.Time {
+	collector.countersMu.Lock()
+	defer collector.countersMu.Unlock()
+	counters := make(map[string]peerCounters, len(status.Peer))
+	created := make(map[string]time.Time, len(status.Peer))
+	for _, peer := range status.Peer {
+		previous, ok := collector.counters[peer.ID]
+		if !ok || peer.RxBytes < previous.rx || peer.TxBytes < previous.tx {
+			previous.created = now
+		}
+		counters[peer.ID] = peerCounters{created: previous.created, rx: peer.RxBytes, tx: peer.TxBytes}
+		created[peer.ID] = previous.created
+	}
+	collector.counters = counters
+	return created
+}
+
+// keyChanges returns the number of node key changes per peer ID. Peers missing from the netmap are forgotten.
+func (collector *PeerCollector) keyChanges(status *tailscaleclient.Status) map[string]int {
+	collector.countersMu.Lock()
+	defer collector.countersMu.Unlock()
+	keys := make(map[string]peerKey, len(status.Peer))
+	changes := make(map[string]int, len(status.Peer))
+	for _, peer := range status.Peer {
+		previous, ok := collector.keys[peer.ID]
+		if ok && previous.publicKey != peer.PublicKey {
+			previous.changes++
+			slog.Warn("peer node key changed", "peer", peer.HostName, "id", peer.ID, "public_key", peer.PublicKey)
+		}
+		keys[peer.ID] = peerKey{publicKey: peer.PublicKey, changes: previous.changes}
+		changes[peer.ID] = previous.changes
+	}
+	collector.keys = keys
+	return changes
+}
+
+// byteRates updates the byte rates of the peers once their last sample is RateInterval old. A counter lower than
+// its last sample was reset, it counts from zero like rate() in Prometheus. Peers missing from the netmap are forgotten.
+func (collector *PeerCollector) byteRates(status *tailscaleclient.Status, now time.Time) map[string]peerRate {
+	collector.countersMu.Lock()
+	defer collector.countersMu.Unlock()
+	rates := make(map[string]peerRate, len(status.Peer))
+	for _, peer := range status.Peer {
+		rate, ok := collector.rates[peer.ID]
+		elapsed := now.Sub(rate.at)
+		switch {
+		case !ok:
+			rate = peerRate{rx: peer.RxBytes, tx: peer.TxBytes, at: now}
+		case elapsed >= collector.RateInterval:
+			rxDelta, txDelta := peer.RxBytes-rate.rx, peer.TxBytes-rate.tx
+			if rxDelta < 0 {
+				rxDelta = peer.RxBytes
+			}
+			if txDelta < 0 {
+				txDelta = peer.TxBytes
+			}
+			rate = peerRate{rx: peer.RxBytes, tx: peer.TxBytes, at: now, computed: true,
+				rxRate: float64(rxDelta) / elapsed.Seconds(), txRate: float64(txDelta) / elapsed.Seconds()}
+		}
+		rates[peer.ID] = rate
+	}
+	collector.rates = rates
+	return rates
+}
+
+// withDeparted returns a copy of status with the peers gone from the netmap within GracePeriod added back offline,
+// and the IDs of those peers.
+func (collector *PeerCollector) withDeparted(status *tailscaleclient.Status, now time.Time) (*tailscaleclient.Status, map[string]bool) {
+	collector.countersMu.Lock()
+	defer collector.countersMu.Unlock()
+	seen := make(map[string]seenPeer, len(status.Peer))
+	present := map[string]bool{}
+	for _, peer := range status.Peer {
+		seen[peer.ID] = seenPeer{peer: peer, lastSeen: now}
+		present[peer.ID] = true
+	}
+	withDeparted := *status
+	withDeparted.Peer = maps.Clone(status.Peer)
+	departed := map[string]bool{}
+	for id, previous := range collector.seen {
+		if present[id] || now.Sub(previous.lastSeen) > collector.GracePeriod {
+			continue
+		}
+		previous.peer.Online = false
+		previous.peer.Active = false
+		previous.peer.CurAddr = ""
+		seen[id] = previous
+		withDeparted.Peer[id] = previous.peer
+		departed[id] = true
+	}
+	collector.seen = seen
+	return &withDeparted, departed
+}
+
+// shortDuration formats d without zero minutes and seconds, 168h instead of 168h0m0s.
+func shortDuration(d time.Duration) string {
+	formatted := d.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
+
+// subnetRoutes returns allowedIPs without the node's own addresses
+func subnetRoutes(allowedIPs []string, ips []string) []string {
+	var routes []string
+	for _, allowed := range allowedIPs {
+		prefix, err := netip.ParsePrefix(allowed)
+		if err == nil && prefix.IsSingleIP() && slices.Contains(ips, prefix.Addr().String()) {
+			continue
+		}
+		routes = append(routes, allowed)
+	}
+	return routes
+}
+
+// firstIP returns the first Tailscale address of a node, empty for a logged out node without addresses.
+func firstIP(ips []string) string {
+	if len(ips) == 0 {
+		return ""
+	}
+	return ips[0]
+}
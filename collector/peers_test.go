@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"tailscale-exporter/tailscaleclient"
+	"testing"
+	"time"
+)
+
+// gatherPeers collects a PeerCollector on the canned status and returns the gathered families by name.
+func gatherPeers(t *testing.T, statusJSON string) map[string]*dto.MetricFamily {
+	t.Helper()
+	status := &tailscaleclient.Status{}
+	if err := json.Unmarshal([]byte(statusJSON), status); err != nil {
+		t.Fatalf("error on unmarshal status: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewScrapeCollector("peers", &PeerCollector{Provider: &tailscaleclient.FakeProvider{Result: status}, Timeout: time.Second}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("error on gather: %v", err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	if value := byName["exporter_scrape_success"].GetMetric()[0].GetGauge().GetValue(); value != 1 {
+		t.Fatalf("exporter_scrape_success = %v, want 1", value)
+	}
+	return byName
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestPeerCollectorNoPeers(t *testing.T) {
+	// a single node tailnet has a null peer map
+	families := gatherPeers(t, `{"BackendState":"Running","Self":{"ID":"s1","HostName":"self","DNSName":"self.ts.net.","TailscaleIPs":["100.64.0.1"]},"Peer":null}`)
+	if value := families["peers_total"].GetMetric()[0].GetGauge().GetValue(); value != 0 {
+		t.Errorf("peers_total = %v, want 0", value)
+	}
+	if family, ok := families["peer_rx"]; ok {
+		t.Errorf("peer_rx has %d series, want none", len(family.GetMetric()))
+	}
+}
+
+func TestPeerCollectorPeerWithoutAddresses(t *testing.T) {
+	families := gatherPeers(t, `{"BackendState":"Running","Self":{"ID":"s1","HostName":"self","DNSName":"self.ts.net.","TailscaleIPs":["100.64.0.1"]},
+		"Peer":{"k1":{"ID":"p1","HostName":"gone","DNSName":"gone.ts.net.","TailscaleIPs":[],"ExitNode":true,"RxBytes":10,"UserID":1}}}`)
+	series := families["peer_rx"].GetMetric()
+	if len(series) != 1 {
+		t.Fatalf("peer_rx has %d series, want 1", len(series))
+	}
+	if name, ip := labelValue(series[0], "peer_name"), labelValue(series[0], "peer_ip"); name != "gone" || ip != "" {
+		t.Errorf("peer_rx peer_name=%q peer_ip=%q, want gone and empty", name, ip)
+	}
+	exitNode := families["exit_node_in_use"].GetMetric()[0]
+	if id, ip := labelValue(exitNode, "exit_node_id"), labelValue(exitNode, "exit_node_ip"); id != "p1" || ip != "" {
+		t.Errorf("exit_node_in_use exit_node_id=%q exit_node_ip=%q, want p1 and empty", id, ip)
+	}
+}
+
+func TestPeerCollectorLoggedOut(t *testing.T) {
+	families := gatherPeers(t, `{"BackendState":"NeedsLogin","Self":{"ID":"","HostName":"self","TailscaleIPs":null}}`)
+	if value := families["login_required"].GetMetric()[0].GetGauge().GetValue(); value != 1 {
+		t.Errorf("login_required = %v, want 1", value)
+	}
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdminAPIPolicy identifies a version of the tailnet policy file.
+type AdminAPIPolicy struct {
+	// Hash is a short SHA-256 of the policy file, stable across API calls unlike some ETags
+	Hash         string
+	LastModified time.Time
+}
+
+// GetPolicy fetches the tailnet policy file as HuJSON, the way it was written.
+func (client *AdminAPIClient) GetPolicy(ctx context.Context) (*AdminAPIPolicy, error) {
+	body, header, err := client.getRaw(ctx, "/acl")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	policy := &AdminAPIPolicy{Hash: hex.EncodeToString(sum[:6])}
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		policy.LastModified = lastModified
+	}
+	return policy, nil
+}
+
+var (
+	PolicyInfoDesc         = prometheus.NewDesc("policy_info", "Current tailnet policy file from the Admin API, always 1.", []string{"hash"}, nil)
+	PolicyChangesDesc      = prometheus.NewDesc("policy_changes_total", "Number of tailnet policy file changes seen by the exporter.", nil, nil)
+	PolicyLastChangeDesc   = prometheus.NewDesc("policy_last_change_timestamp_seconds", "When the exporter first saw the current tailnet policy file.", nil, nil)
+	PolicyLastModifiedDesc = prometheus.NewDesc("policy_last_modified_timestamp_seconds", "Last modification of the tailnet policy file, if the Admin API tells it.", nil, nil)
+)
+
+// PolicyCollector exports which tailnet policy file is deployed, so policy changes can be correlated with
+// connectivity incidents. Changes are counted between scrapes, the first one counts nothing.
+type PolicyCollector struct {
+	Client  *AdminAPIClient
+	Timeout time.Duration
+
+	mu         sync.Mutex
+	hash       string
+	changes    int
+	lastChange time.Time
+}
+
+func (collector *PolicyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PolicyInfoDesc
+	ch <- PolicyChangesDesc
+	ch <- PolicyLastChangeDesc
+	ch <- PolicyLastModifiedDesc
+}
+
+func (collector *PolicyCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *PolicyCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	policy, err := collector.Client.GetPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.hash != policy.Hash {
+		if collector.hash != "" {
+			collector.changes++
+		}
+		collector.hash = policy.Hash
+		collector.lastChange = time.Now()
+	}
+	ch <- prometheus.MustNewConstMetric(PolicyInfoDesc, prometheus.GaugeValue, 1, policy.Hash)
+	ch <- prometheus.MustNewConstMetric(PolicyChangesDesc, prometheus.CounterValue, float64(collector.changes))
+	ch <- prometheus.MustNewConstMetric(PolicyLastChangeDesc, prometheus.GaugeValue, float64(collector.lastChange.Unix()))
+	if !policy.LastModified.IsZero() {
+		ch <- prometheus.MustNewConstMetric(PolicyLastModifiedDesc, prometheus.GaugeValue, float64(policy.LastModified.Unix()))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"tailscale.com/client/local"
+	"tailscale.com/net/tsaddr"
+	"time"
+)
+
+var (
+	PrefsRouteAllDesc         = prometheus.NewDesc("prefs_route_all", "Whether subnet routes of other nodes are accepted (--accept-routes).", nil, nil)
+	PrefsAcceptDNSDesc        = prometheus.NewDesc("prefs_accept_dns", "Whether the tailnet DNS configuration is used (--accept-dns).", nil, nil)
+	PrefsShieldsUpDesc        = prometheus.NewDesc("prefs_shields_up", "Whether incoming connections are blocked (--shields-up).", nil, nil)
+	PrefsRunSSHDesc           = prometheus.NewDesc("prefs_run_ssh", "Whether Tailscale SSH server is enabled (--ssh).", nil, nil)
+	PrefsExitNodeSetDesc      = prometheus.NewDesc("prefs_exit_node_set", "Whether an exit node is selected (--exit-node).", nil, nil)
+	PrefsAdvertiseExitDesc    = prometheus.NewDesc("prefs_advertise_exit_node", "Whether this node offers itself as exit node (--advertise-exit-node).", nil, nil)
+	PrefsAdvertisedRoutesDesc = prometheus.NewDesc("prefs_advertised_routes", "Number of advertised subnet routes, exit node routes excluded (--advertise-routes).", nil, nil)
+)
+
+// PrefsCollector exports node preferences read from LocalAPI, to spot configuration drift across a fleet.
+type PrefsCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *PrefsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PrefsRouteAllDesc
+	ch <- PrefsAcceptDNSDesc
+	ch <- PrefsShieldsUpDesc
+	ch <- PrefsRunSSHDesc
+	ch <- PrefsExitNodeSetDesc
+	ch <- PrefsAdvertiseExitDesc
+	ch <- PrefsAdvertisedRoutesDesc
+}
+
+func (collector *PrefsCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *PrefsCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	prefs, err := collector.LocalClient.GetPrefs(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get prefs: %w", err)
+	}
+	routes := 0
+	for _, route := range prefs.AdvertiseRoutes {
+		if !tsaddr.IsExitRoute(route) {
+			routes++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(PrefsRouteAllDesc, prometheus.GaugeValue, boolToFloat(prefs.RouteAll))
+	ch <- prometheus.MustNewConstMetric(PrefsAcceptDNSDesc, prometheus.GaugeValue, boolToFloat(prefs.CorpDNS))
+	ch <- prometheus.MustNewConstMetric(PrefsShieldsUpDesc, prometheus.GaugeValue, boolToFloat(prefs.ShieldsUp))
+	ch <- prometheus.MustNewConstMetric(PrefsRunSSHDesc, prometheus.GaugeValue, boolToFloat(prefs.RunSSH))
+	ch <- prometheus.MustNewConstMetric(PrefsExitNodeSetDesc, prometheus.GaugeValue, boolToFloat(!prefs.ExitNodeID.IsZero() || prefs.ExitNodeIP.IsValid()))
+	ch <- prometheus.MustNewConstMetric(PrefsAdvertiseExitDesc, prometheus.GaugeValue, boolToFloat(prefs.AdvertisesExitNode()))
+	ch <- prometheus.MustNewConstMetric(PrefsAdvertisedRoutesDesc, prometheus.GaugeValue, float64(routes))
+	return nil
+}
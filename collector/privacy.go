@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"slices"
+	"strings"
+)
+
+// identifyingLabels hold host names, DNS names, IPs, user names and stable device identifiers: node IDs and keys.
+// target holds host:port of probes and serve handlers.
+var identifyingLabels = map[string]bool{
+	"id": true, "peer_id": true, "exit_node_id": true, "device_id": true, "node_id": true, "public_key": true,
+	"name": true, "given_name": true, "ip": true,
+	"peer_name": true, "peer_given_name": true, "peer_ip": true, "peer": true,
+	"dns_name": true, "host": true, "hostname": true, "domain": true, "device_name": true,
+	"exit_node_name": true, "exit_node_ip": true, "target": true, "remote_host": true,
+	"login_name": true, "display_name": true, "user": true,
+}
+
+// PrivacyGatherer hashes or drops the identifying label values of everything gathered,
+// for organizations that must not store user-identifying data in their central Prometheus.
+type PrivacyGatherer struct {
+	Gatherer prometheus.Gatherer
+	// Hash replaces values with a salted hash, so series stay apart and can be joined.
+	// Otherwise the labels are dropped and series only differing in them are merged, see mergeDuplicates.
+	Hash bool
+	Salt string
+}
+
+func (gatherer *PrivacyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := gatherer.Gatherer.Gather()
+	for _, family := range families {
+		if gatherer.Hash {
+			for _, metric := range family.Metric {
+				// registries share the labels between gathers, they must not be changed in place
+				metric.Label = slices.Clone(metric.Label)
+				for i, label := range metric.Label {
+					if identifyingLabels[label.GetName()] && label.GetValue() != "" {
+						metric.Label[i] = &dto.LabelPair{Name: label.Name, Value: new(gatherer.hash(label.GetValue()))}
+					}
+				}
+			}
+			continue
+		}
+		dropIdentifyingLabels(family)
+	}
+	return families, err
+}
+
+func (gatherer *PrivacyGatherer) hash(value string) string {
+	sum := sha256.Sum256([]byte(gatherer.Salt + value))
+	return hex.EncodeToString(sum[:6])
+}
+
+func dropIdentifyingLabels(family *dto.MetricFamily) {
+	for _, metric := range family.Metric {
+		var labels []*dto.LabelPair
+		for _, label := range metric.Label {
+			if !identifyingLabels[label.GetName()] {
+				labels = append(labels, label)
+			}
+		}
+		metric.Label = labels
+	}
+	mergeDuplicates(family)
+}
+
+// nonAdditiveSuffixes end the names of gauges whose values mean nothing summed:
+// info series, timestamps, durations, DERP regions and posture attribute values.
+var nonAdditiveSuffixes = []string{"_info", "_seconds", "_region", "_attribute"}
+
+// additive reports whether the series of family can be summed: counters, histograms and gauges counting things.
+func additive(family *dto.MetricFamily) bool {
+	switch family.GetType() {
+	case dto.MetricType_COUNTER, dto.MetricType_HISTOGRAM:
+		return true
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		for _, suffix := range nonAdditiveSuffixes {
+			if strings.HasSuffix(family.GetName(), suffix) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// mergeDuplicates merges the series of family with the same labels, e.g. after labels were dropped.
+// Series of additive families are summed, colliding series of other families are dropped as none of their values is right.
+func mergeDuplicates(family *dto.MetricFamily) {
+	sum := additive(family)
+	var merged []*dto.Metric
+	byKey := map[string]*dto.Metric{}
+	collided := map[*dto.Metric]bool{}
+	for _, metric := range family.Metric {
+		var key strings.Builder
+		for _, label := range metric.Label {
+			key.WriteString(label.GetName() + "\xff" + label.GetValue() + "\xff")
+		}
+		existing, ok := byKey[key.String()]
+		if !ok {
+			byKey[key.String()] = metric
+			merged = append(merged, metric)
+			continue
+		}
+		if !sum || !addMetric(existing, metric) {
+			collided[existing] = true
+		}
+	}
+	family.Metric = slices.DeleteFunc(merged, func(metric *dto.Metric) bool { return collided[metric] })
+}
+
+// addMetric adds the value of metric to existing, false if they cannot be added, e.g. histograms with other buckets.
+func addMetric(existing *dto.Metric, metric *dto.Metric) bool {
+	switch {
+	case existing.Counter != nil && metric.Counter != nil:
+		existing.Counter.Value = new(existing.Counter.GetValue() + metric.Counter.GetValue())
+	case existing.Gauge != nil && metric.Gauge != nil:
+		existing.Gauge.Value = new(existing.Gauge.GetValue() + metric.Gauge.GetValue())
+	case existing.Untyped != nil && metric.Untyped != nil:
+		existing.Untyped.Value = new(existing.Untyped.GetValue() + metric.Untyped.GetValue())
+	case existing.Histogram != nil && metric.Histogram != nil:
+		buckets, other := existing.Histogram.Bucket, metric.Histogram.Bucket
+		if len(buckets) != len(other) {
+			return false
+		}
+		for i, bucket := range buckets {
+			if bucket.GetUpperBound() != other[i].GetUpperBound() {
+				return false
+			}
+		}
+		for i, bucket := range buckets {
+			bucket.CumulativeCount = new(bucket.GetCumulativeCount() + other[i].GetCumulativeCount())
+		}
+		existing.Histogram.SampleCount = new(existing.Histogram.GetSampleCount() + metric.Histogram.GetSampleCount())
+		existing.Histogram.SampleSum = new(existing.Histogram.GetSampleSum() + metric.Histogram.GetSampleSum())
+	default:
+		return false
+	}
+	return true
+}
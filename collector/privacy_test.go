@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+// gatherSeries returns the samples of gatherer by family, keyed by their label pairs, histograms by sample count.
+func gatherSeries(t *testing.T, gatherer prometheus.Gatherer) map[string]map[string]float64 {
+	t.Helper()
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("error on gather: %v", err)
+	}
+	series := map[string]map[string]float64{}
+	for _, family := range families {
+		values := map[string]float64{}
+		for _, metric := range family.Metric {
+			key := ""
+			for _, label := range metric.Label {
+				key += label.GetName() + "=" + label.GetValue() + ","
+			}
+			switch {
+			case metric.Counter != nil:
+				values[key] = metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				values[key] = metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				values[key] = float64(metric.Histogram.GetSampleCount())
+			}
+		}
+		series[family.GetName()] = values
+	}
+	return series
+}
+
+func TestPrivacyGathererRedact(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rx := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "peer_rx_bytes_total"}, []string{"peer_name", "os"})
+	online := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_online"}, []string{"peer_name", "os"})
+	created := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_created_timestamp_seconds"}, []string{"peer_name", "os"})
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_info"}, []string{"peer_name", "os"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "peer_ping_latency_seconds", Buckets: []float64{0.01, 0.1}}, []string{"peer"})
+	registry.MustRegister(rx, online, created, info, latency)
+	for peer, os := range map[string]string{"a": "linux", "b": "linux", "c": "windows"} {
+		rx.WithLabelValues(peer, os).Add(10)
+		online.WithLabelValues(peer, os).Set(1)
+		created.WithLabelValues(peer, os).Set(1700000000)
+		info.WithLabelValues(peer, os).Set(1)
+		latency.WithLabelValues(peer).Observe(0.05)
+	}
+
+	series := gatherSeries(t, &PrivacyGatherer{Gatherer: registry})
+	tests := []struct {
+		family string
+		want   map[string]float64
+	}{
+		// counters and count-like gauges are summed
+		{"peer_rx_bytes_total", map[string]float64{"os=linux,": 20, "os=windows,": 10}},
+		{"peer_online", map[string]float64{"os=linux,": 2, "os=windows,": 1}},
+		// timestamps and info series of several peers are dropped, a single one is kept
+		{"peer_created_timestamp_seconds", map[string]float64{"os=windows,": 1700000000}},
+		{"peer_info", map[string]float64{"os=windows,": 1}},
+		// histograms sum their buckets
+		{"peer_ping_latency_seconds", map[string]float64{"": 3}},
+	}
+	for _, test := range tests {
+		got := series[test.family]
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.family, got, test.want)
+			continue
+		}
+		for key, value := range test.want {
+			if got[key] != value {
+				t.Errorf("%s{%s}: got %v, want %v", test.family, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestMergeDuplicatesHistogramBuckets(t *testing.T) {
+	histogram := func(bounds ...float64) *dto.Metric {
+		metric := &dto.Metric{Histogram: &dto.Histogram{SampleCount: new(uint64(1)), SampleSum: new(1.0)}}
+		for _, bound := range bounds {
+			metric.Histogram.Bucket = append(metric.Histogram.Bucket, &dto.Bucket{UpperBound: new(bound), CumulativeCount: new(uint64(1))})
+		}
+		return metric
+	}
+	histogramType := dto.MetricType_HISTOGRAM
+	family := &dto.MetricFamily{Name: new("duration_seconds"), Type: &histogramType, Metric: []*dto.Metric{histogram(0.1, 1), histogram(0.1, 1)}}
+	mergeDuplicates(family)
+	if len(family.Metric) != 1 || family.Metric[0].Histogram.GetSampleCount() != 2 || family.Metric[0].Histogram.Bucket[1].GetCumulativeCount() != 2 {
+		t.Errorf("same buckets: got %v, want one series of 2 samples", family.Metric)
+	}
+	family = &dto.MetricFamily{Name: new("duration_seconds"), Type: &histogramType, Metric: []*dto.Metric{histogram(0.1, 1), histogram(0.5, 1)}}
+	mergeDuplicates(family)
+	if len(family.Metric) != 0 {
+		t.Errorf("other buckets: got %v, want them dropped", family.Metric)
+	}
+}
+
+func TestPrivacyGathererHash(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_key_info"}, []string{"peer_name", "peer_id", "public_key", "peer_ip", "os"})
+	registry.MustRegister(info)
+	info.WithLabelValues("a", "n1", "nodekey:1", "100.64.0.2", "linux").Set(1)
+	info.WithLabelValues("b", "n2", "nodekey:2", "", "linux").Set(1)
+
+	hashed := func(salt string) []*dto.Metric {
+		families, err := (&PrivacyGatherer{Gatherer: registry, Hash: true, Salt: salt}).Gather()
+		if err != nil {
+			t.Fatalf("error on gather: %v", err)
+		}
+		return families[0].Metric
+	}
+	metrics := hashed("s1")
+	if len(metrics) != 2 {
+		t.Fatalf("got %d series, want 2 kept apart", len(metrics))
+	}
+	gatherer := &PrivacyGatherer{Salt: "s1"}
+	for i, want := range []map[string]string{
+		{"peer_name": gatherer.hash("a"), "peer_id": gatherer.hash("n1"), "public_key": gatherer.hash("nodekey:1"), "peer_ip": gatherer.hash("100.64.0.2"), "os": "linux"},
+		// empty values stay empty
+		{"peer_name": gatherer.hash("b"), "peer_id": gatherer.hash("n2"), "public_key": gatherer.hash("nodekey:2"), "peer_ip": "", "os": "linux"},
+	} {
+		for name, value := range want {
+			if got := labelValue(metrics[i], name); got != value {
+				t.Errorf("series %d %s: got %q, want %q", i, name, got, value)
+			}
+		}
+	}
+	if labelValue(hashed("s2")[0], "peer_name") == labelValue(metrics[0], "peer_name") {
+		t.Errorf("hashes with different salts are equal")
+	}
+}
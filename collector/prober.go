@@ -0,0 +1,378 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// ProbeConfig selects the targets of the PeerProber.
+type ProbeConfig struct {
+	Peers      []string `yaml:"peers"`
+	TCPTargets []string `yaml:"tcp_targets"`
+	DNSNames   []string `yaml:"dns_names"`
+	PeerAPI    []string `yaml:"peerapi"`
+	// DNSServer resolves DNSNames instead of the system resolver, e.g. 100.100.100.100:53
+	DNSServer string        `yaml:"dns_server"`
+	Interval  time.Duration `yaml:"interval"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// PeerProber pings configured peers in background and records latency histograms.
+// TCP targets (peer:port) are connected to through the tunnel, to verify the actual services are reachable.
+// DNS names are resolved, as MagicDNS breakage does not show in the status.
+// PeerAPI targets get an HTTP request to their PeerAPI, which checks the data plane end to end unlike the Online flag.
+// Targets and interval can be changed while running with SetTargets.
+type PeerProber struct {
+	CLI      tailscaleclient.CLI
+	Timeout  time.Duration
+	Resolver *net.Resolver
+	// Provider and HTTPClient are needed by PeerAPI targets, for the PeerAPI URLs and the requests
+	Provider   tailscaleclient.StatusProvider
+	HTTPClient *http.Client
+
+	mu             sync.Mutex
+	targets        []string
+	tcpTargets     []string
+	dnsNames       []string
+	peerAPITargets []string
+	peerAPIProbed  []string
+	interval       time.Duration
+
+	latency     *prometheus.HistogramVec
+	success     *prometheus.CounterVec
+	failures    *prometheus.CounterVec
+	tcpSuccess  *prometheus.GaugeVec
+	tcpDuration *prometheus.HistogramVec
+	dnsSuccess  *prometheus.GaugeVec
+	dnsDuration *prometheus.HistogramVec
+
+	peerAPISuccess  *prometheus.GaugeVec
+	peerAPIDuration *prometheus.HistogramVec
+}
+
+func NewPeerProber(probe ProbeConfig) *PeerProber {
+	prober := &PeerProber{
+		Timeout:    probe.Timeout,
+		Resolver:   net.DefaultResolver,
+		HTTPClient: http.DefaultClient,
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "peer_ping_latency_seconds",
+			Unit:    "seconds",
+			Help:    "Latency of tailscale pings to the peer.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+		}, []string{"peer", "path"}),
+		success: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peer_ping_success_total",
+			Help: "Number of successful tailscale pings to the peer.",
+		}, []string{"peer"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peer_ping_failures_total",
+			Help: "Number of failed tailscale pings to the peer.",
+		}, []string{"peer"}),
+		tcpSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "peer_tcp_probe_success",
+			Help: "Whether the last TCP connection to the peer service succeeded.",
+		}, []string{"target"}),
+		tcpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "peer_tcp_connect_duration_seconds",
+			Unit:    "seconds",
+			Help:    "Duration of successful TCP connections to the peer service.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+		}, []string{"target"}),
+		dnsSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_probe_success",
+			Help: "Whether the last resolution of the name returned addresses.",
+		}, []string{"name"}),
+		dnsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dns_probe_duration_seconds",
+			Unit:    "seconds",
+			Help:    "Duration of successful resolutions of the name.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 13),
+		}, []string{"name"}),
+		peerAPISuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "peer_peerapi_probe_success",
+			Help: "Whether the last HTTP request to the PeerAPI of the peer got a response, of any status.",
+		}, []string{"peer"}),
+		peerAPIDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "peer_peerapi_response_duration_seconds",
+			Unit:    "seconds",
+			Help:    "Response time of successful HTTP requests to the PeerAPI of the peer.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+		}, []string{"peer"}),
+	}
+	if probe.DNSServer != "" {
+		// the system resolver may not go through MagicDNS at all, e.g. with --accept-dns=false
+		prober.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+				return dialer.DialContext(ctx, network, probe.DNSServer)
+			},
+		}
+	}
+	prober.SetTargets(probe)
+	return prober
+}
+
+// SetTargets replaces probe targets. Series of kept targets continue, removed ones are dropped.
+func (prober *PeerProber) SetTargets(probe ProbeConfig) {
+	targets, tcpTargets, dnsNames := probe.Peers, probe.TCPTargets, probe.DNSNames
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	for _, target := range prober.targets {
+		if !slices.Contains(targets, target) {
+			prober.latency.DeletePartialMatch(prometheus.Labels{"peer": target})
+			prober.success.DeleteLabelValues(target)
+			prober.failures.DeleteLabelValues(target)
+		}
+	}
+	for _, target := range targets {
+		// make series visible before first probe
+		prober.success.WithLabelValues(target)
+		prober.failures.WithLabelValues(target)
+	}
+	for _, target := range prober.tcpTargets {
+		if !slices.Contains(tcpTargets, target) {
+			prober.tcpSuccess.DeleteLabelValues(target)
+			prober.tcpDuration.DeleteLabelValues(target)
+		}
+	}
+	for _, name := range prober.dnsNames {
+		if !slices.Contains(dnsNames, name) {
+			prober.dnsSuccess.DeleteLabelValues(name)
+			prober.dnsDuration.DeleteLabelValues(name)
+		}
+	}
+	if !slices.Equal(prober.peerAPITargets, probe.PeerAPI) {
+		// the probed peers are only known at the next round
+		prober.peerAPISuccess.Reset()
+		prober.peerAPIDuration.Reset()
+		prober.peerAPIProbed = nil
+	}
+	prober.targets = slices.Clone(targets)
+	prober.tcpTargets = slices.Clone(tcpTargets)
+	prober.dnsNames = slices.Clone(dnsNames)
+	prober.peerAPITargets = slices.Clone(probe.PeerAPI)
+	prober.interval = probe.Interval
+}
+
+func (prober *PeerProber) Run(ctx context.Context) {
+	for {
+		prober.ProbeAll(ctx)
+		prober.mu.Lock()
+		interval := prober.interval
+		prober.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ProbeAll pings, connects to, resolves and requests every target once, in parallel.
+func (prober *PeerProber) ProbeAll(ctx context.Context) {
+	prober.mu.Lock()
+	targets, tcpTargets, dnsNames := prober.targets, prober.tcpTargets, prober.dnsNames
+	prober.mu.Unlock()
+	peerAPIURLs := prober.peerAPIURLs(ctx)
+	wg := sync.WaitGroup{}
+	for _, target := range targets {
+		wg.Go(func() { prober.probe(ctx, target) })
+	}
+	for _, target := range tcpTargets {
+		wg.Go(func() { prober.probeTCP(ctx, target) })
+	}
+	for _, name := range dnsNames {
+		wg.Go(func() { prober.probeDNS(ctx, name) })
+	}
+	for peer, url := range peerAPIURLs {
+		wg.Go(func() { prober.probePeerAPI(ctx, peer, url) })
+	}
+	wg.Wait()
+}
+
+// peerAPIURLs returns the PeerAPI URL of the online peers matching the PeerAPI targets by host name or
+// MagicDNS name, "*" matches all, by the MagicDNS name of the peer. Series of peers no longer probed are dropped.
+func (prober *PeerProber) peerAPIURLs(ctx context.Context) map[string]string {
+	prober.mu.Lock()
+	peerAPITargets := prober.peerAPITargets
+	prober.mu.Unlock()
+	if len(peerAPITargets) == 0 || prober.Provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	status, err := prober.Provider.Status(ctx)
+	if err != nil {
+		slog.Warn("peerapi probe failed", "err", err)
+		return nil
+	}
+	urls := map[string]string{}
+	for _, peer := range status.Peer {
+		name := strings.Split(peer.DNSName, ".")[0]
+		if !peer.Online || len(peer.PeerAPIURL) == 0 {
+			continue
+		}
+		if slices.Contains(peerAPITargets, "*") || slices.Contains(peerAPITargets, peer.HostName) || slices.Contains(peerAPITargets, name) {
+			urls[name] = peer.PeerAPIURL[0]
+		}
+	}
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	for _, peer := range prober.peerAPIProbed {
+		if _, ok := urls[peer]; !ok {
+			prober.peerAPISuccess.DeleteLabelValues(peer)
+			prober.peerAPIDuration.DeleteLabelValues(peer)
+		}
+	}
+	prober.peerAPIProbed = slices.Collect(maps.Keys(urls))
+	return urls
+}
+
+func (prober *PeerProber) probePeerAPI(ctx context.Context, peer string, url string) {
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := ProbeHTTP(ctx, prober.HTTPClient, url+"/")
+	duration := time.Since(start)
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if !slices.Contains(prober.peerAPIProbed, peer) || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	if err != nil {
+		slog.Warn("peerapi probe failed", "peer", peer, "err", err)
+		prober.peerAPISuccess.WithLabelValues(peer).Set(0)
+		return
+	}
+	prober.peerAPISuccess.WithLabelValues(peer).Set(1)
+	prober.peerAPIDuration.WithLabelValues(peer).Observe(duration.Seconds())
+}
+
+func (prober *PeerProber) probe(ctx context.Context, target string) {
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout+time.Second*5)
+	defer cancel()
+	result, err := prober.CLI.Ping(ctx, target, prober.Timeout)
+	if !prober.active(target) || ctx.Err() == context.Canceled {
+		// removed by SetTargets while pinging, or shutting down
+		return
+	}
+	if err != nil {
+		slog.Warn("ping failed", "target", target, "err", err)
+		prober.failures.WithLabelValues(target).Inc()
+		return
+	}
+	prober.success.WithLabelValues(target).Inc()
+	prober.latency.WithLabelValues(target, result.Path()).Observe(result.Latency.Seconds())
+}
+
+func (prober *PeerProber) probeTCP(ctx context.Context, target string) {
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := ProbeTCP(ctx, target)
+	duration := time.Since(start)
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if !slices.Contains(prober.tcpTargets, target) || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	if err != nil {
+		slog.Warn("tcp probe failed", "target", target, "err", err)
+		prober.tcpSuccess.WithLabelValues(target).Set(0)
+		return
+	}
+	prober.tcpSuccess.WithLabelValues(target).Set(1)
+	prober.tcpDuration.WithLabelValues(target).Observe(duration.Seconds())
+}
+
+func (prober *PeerProber) probeDNS(ctx context.Context, name string) {
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	start := time.Now()
+	addrs, err := prober.Resolver.LookupHost(ctx, name)
+	duration := time.Since(start)
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if !slices.Contains(prober.dnsNames, name) || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses")
+	}
+	if err != nil {
+		slog.Warn("dns probe failed", "name", name, "err", err)
+		prober.dnsSuccess.WithLabelValues(name).Set(0)
+		return
+	}
+	prober.dnsSuccess.WithLabelValues(name).Set(1)
+	prober.dnsDuration.WithLabelValues(name).Observe(duration.Seconds())
+}
+
+func (prober *PeerProber) active(target string) bool {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	return slices.Contains(prober.targets, target)
+}
+
+func (prober *PeerProber) Describe(ch chan<- *prometheus.Desc) {
+	prober.latency.Describe(ch)
+	prober.success.Describe(ch)
+	prober.failures.Describe(ch)
+	prober.tcpSuccess.Describe(ch)
+	prober.tcpDuration.Describe(ch)
+	prober.dnsSuccess.Describe(ch)
+	prober.dnsDuration.Describe(ch)
+	prober.peerAPISuccess.Describe(ch)
+	prober.peerAPIDuration.Describe(ch)
+}
+
+func (prober *PeerProber) Collect(ch chan<- prometheus.Metric) {
+	prober.latency.Collect(ch)
+	prober.success.Collect(ch)
+	prober.failures.Collect(ch)
+	prober.tcpSuccess.Collect(ch)
+	prober.tcpDuration.Collect(ch)
+	prober.dnsSuccess.Collect(ch)
+	prober.dnsDuration.Collect(ch)
+	prober.peerAPISuccess.Collect(ch)
+	prober.peerAPIDuration.Collect(ch)
+}
+
+// ProbeTCP connects to target (host:port) and closes the connection right away.
+func ProbeTCP(ctx context.Context, target string) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ProbeHTTP requests url and reads the response, of any status: an answer proves the path to the server.
+func ProbeHTTP(ctx context.Context, client *http.Client, url string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(io.Discard, response.Body)
+	return err
+}
@@ -0,0 +1,201 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RelabelConfig is a rule like Prometheus metric_relabel_configs, the metric name, namespace included, is the __name__ label.
+// Supported actions are replace, keep, drop, labelmap, labeldrop and labelkeep.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+// UnmarshalYAML applies the defaults of Prometheus before decoding, so an explicitly empty replacement stays empty.
+func (cfg *RelabelConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain RelabelConfig
+	*cfg = RelabelConfig{Separator: ";", Regex: "(.*)", Replacement: "$1", Action: "replace"}
+	return unmarshal((*plain)(cfg))
+}
+
+var (
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+)
+
+// validLabel reports whether value may be stored as label name, __name__ holds a metric name.
+func validLabel(name, value string) bool {
+	if name == "__name__" {
+		return metricNameRe.MatchString(value)
+	}
+	return labelNameRe.MatchString(name)
+}
+
+type relabelRule struct {
+	RelabelConfig
+	re *regexp.Regexp
+}
+
+// RelabelGatherer rewrites, keeps or drops the series of Gatherer by relabel rules, before any output sees them.
+// Series left with the same labels are merged, see mergeDuplicates.
+type RelabelGatherer struct {
+	Gatherer prometheus.Gatherer
+
+	rules []relabelRule
+}
+
+func NewRelabelGatherer(gatherer prometheus.Gatherer, configs []RelabelConfig) (*RelabelGatherer, error) {
+	rules := make([]relabelRule, 0, len(configs))
+	for i, cfg := range configs {
+		re, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of relabel rule %d: %w", i, err)
+		}
+		switch cfg.Action {
+		case "replace":
+			if cfg.TargetLabel == "" {
+				return nil, fmt.Errorf("relabel rule %d: replace needs target_label", i)
+			}
+			// a target with references to groups is checked when it is expanded
+			if !strings.Contains(cfg.TargetLabel, "$") && !labelNameRe.MatchString(cfg.TargetLabel) {
+				return nil, fmt.Errorf("relabel rule %d: invalid target_label %q", i, cfg.TargetLabel)
+			}
+		case "keep", "drop", "labelmap", "labeldrop", "labelkeep":
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i, cfg.Action)
+		}
+		rules = append(rules, relabelRule{RelabelConfig: cfg, re: re})
+	}
+	return &RelabelGatherer{Gatherer: gatherer, rules: rules}, nil
+}
+
+func (gatherer *RelabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := gatherer.Gatherer.Gather()
+	if len(gatherer.rules) == 0 {
+		return families, err
+	}
+	gathered := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		gathered[family.GetName()] = family
+	}
+	byName := map[string]*dto.MetricFamily{}
+	var relabeled []*dto.MetricFamily
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := map[string]string{"__name__": family.GetName()}
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if !gatherer.relabel(labels) || labels["__name__"] == "" {
+				continue
+			}
+			name := labels["__name__"]
+			metric.Label = labelPairs(labels)
+			target, ok := byName[name]
+			if !ok {
+				// a renamed series takes the type and help of a gathered family of that name, else of its own family
+				source := family
+				if existing, ok := gathered[name]; ok {
+					source = existing
+				}
+				target = &dto.MetricFamily{Name: new(name), Help: source.Help, Type: source.Type, Unit: source.Unit}
+				byName[name] = target
+				relabeled = append(relabeled, target)
+			}
+			if target.GetType() != family.GetType() {
+				slog.Warn("relabel dropped a series of another type", "metric", name, "type", family.GetType(), "from", family.GetName())
+				continue
+			}
+			target.Metric = append(target.Metric, metric)
+		}
+	}
+	for _, family := range relabeled {
+		mergeDuplicates(family)
+	}
+	relabeled = slices.DeleteFunc(relabeled, func(family *dto.MetricFamily) bool { return len(family.Metric) == 0 })
+	slices.SortFunc(relabeled, func(a, b *dto.MetricFamily) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return relabeled, err
+}
+
+// relabel applies the rules to labels in place and reports whether the series is kept.
+func (gatherer *RelabelGatherer) relabel(labels map[string]string) bool {
+	for _, rule := range gatherer.rules {
+		values := make([]string, len(rule.SourceLabels))
+		for i, name := range rule.SourceLabels {
+			values[i] = labels[name]
+		}
+		value := strings.Join(values, rule.Separator)
+		switch rule.Action {
+		case "keep":
+			if !rule.re.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if rule.re.MatchString(value) {
+				return false
+			}
+		case "replace":
+			match := rule.re.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(rule.re.ExpandString(nil, rule.TargetLabel, value, match))
+			replaced := string(rule.re.ExpandString(nil, rule.Replacement, value, match))
+			if !labelNameRe.MatchString(target) || replaced != "" && !validLabel(target, replaced) {
+				continue
+			}
+			if replaced == "" {
+				delete(labels, target)
+			} else {
+				labels[target] = replaced
+			}
+		case "labelmap":
+			mapped := map[string]string{}
+			for name, labelValue := range labels {
+				if match := rule.re.FindStringSubmatchIndex(name); match != nil {
+					if target := string(rule.re.ExpandString(nil, rule.Replacement, name, match)); validLabel(target, labelValue) {
+						mapped[target] = labelValue
+					}
+				}
+			}
+			maps.Copy(labels, mapped)
+		case "labeldrop":
+			for name := range labels {
+				if name != "__name__" && rule.re.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		case "labelkeep":
+			for name := range labels {
+				if name != "__name__" && !rule.re.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// labelPairs returns the sorted labels without empty and __ prefixed ones, so temporary labels of rules never leave.
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	var pairs []*dto.LabelPair
+	for name, value := range labels {
+		if value == "" || strings.HasPrefix(name, "__") {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{Name: new(name), Value: new(value)})
+	}
+	slices.SortFunc(pairs, func(a, b *dto.LabelPair) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return pairs
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"maps"
+	"testing"
+)
+
+func TestRelabelGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rx := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "peer_rx_bytes_total"}, []string{"peer_name", "os"})
+	online := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_online"}, []string{"peer_name", "os"})
+	registry.MustRegister(rx, online)
+	rx.WithLabelValues("a", "linux").Add(10)
+	rx.WithLabelValues("b", "windows").Add(20)
+	online.WithLabelValues("a", "linux").Set(1)
+
+	tests := []struct {
+		name  string
+		rules []RelabelConfig
+		want  map[string]map[string]float64
+	}{
+		{
+			name:  "replace",
+			rules: []RelabelConfig{{SourceLabels: []string{"peer_name", "os"}, Separator: ";", Regex: "(.*);(.*)", TargetLabel: "host", Replacement: "$1-$2", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"host=a-linux,os=linux,peer_name=a,": 10, "host=b-windows,os=windows,peer_name=b,": 20},
+				"peer_online":         {"host=a-linux,os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "replace with an empty value removes the label",
+			rules: []RelabelConfig{{SourceLabels: []string{"os"}, Regex: "linux", TargetLabel: "peer_name", Replacement: "", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,": 10, "os=windows,peer_name=b,": 20},
+				"peer_online":         {"os=linux,": 1},
+			},
+		},
+		{
+			name:  "replace renames the metric",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "peer_(.*)", TargetLabel: "__name__", Replacement: "node_$1", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"node_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+				"node_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "replace skips an invalid metric name",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "peer_online", TargetLabel: "__name__", Replacement: "peer-online", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+				"peer_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "replace skips an invalid expanded target label",
+			rules: []RelabelConfig{{SourceLabels: []string{"os"}, Regex: "(.*)", TargetLabel: "${1}-os", Replacement: "x", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+				"peer_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "temporary labels are stripped",
+			rules: []RelabelConfig{{SourceLabels: []string{"os"}, Regex: "(.*)", TargetLabel: "__tmp_os", Replacement: "$1", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+				"peer_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "keep",
+			rules: []RelabelConfig{{SourceLabels: []string{"os"}, Separator: ";", Regex: "linux", Action: "keep"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10},
+				"peer_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "drop",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Separator: ";", Regex: "peer_online", Action: "drop"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+			},
+		},
+		{
+			name:  "labelmap",
+			rules: []RelabelConfig{{Regex: "peer_(.*)", Replacement: "node_$1", Action: "labelmap"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"node_name=a,os=linux,peer_name=a,": 10, "node_name=b,os=windows,peer_name=b,": 20},
+				"peer_online":         {"node_name=a,os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "labelmap skips invalid label names",
+			rules: []RelabelConfig{{Regex: "peer_(.*)", Replacement: "node-$1", Action: "labelmap"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+				"peer_online":         {"os=linux,peer_name=a,": 1},
+			},
+		},
+		{
+			name:  "labeldrop merges counters",
+			rules: []RelabelConfig{{Regex: "peer_name|os", Action: "labeldrop"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"": 30},
+				"peer_online":         {"": 1},
+			},
+		},
+		{
+			name:  "labelkeep",
+			rules: []RelabelConfig{{Regex: "os", Action: "labelkeep"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,": 10, "os=windows,": 20},
+				"peer_online":         {"os=linux,": 1},
+			},
+		},
+		{
+			name:  "renaming onto a family of another type drops the series",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "peer_online", TargetLabel: "__name__", Replacement: "peer_rx_bytes_total", Action: "replace"}},
+			want: map[string]map[string]float64{
+				"peer_rx_bytes_total": {"os=linux,peer_name=a,": 10, "os=windows,peer_name=b,": 20},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gatherer, err := NewRelabelGatherer(registry, test.rules)
+			if err != nil {
+				t.Fatalf("error on rules: %v", err)
+			}
+			series := gatherSeries(t, gatherer)
+			if len(series) != len(test.want) {
+				t.Fatalf("got families %v, want %v", series, test.want)
+			}
+			for family, want := range test.want {
+				if !maps.Equal(series[family], want) {
+					t.Errorf("%s: got %v, want %v", family, series[family], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRelabelGathererInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RelabelConfig
+	}{
+		{"invalid regex", RelabelConfig{Regex: "(", Action: "keep"}},
+		{"unknown action", RelabelConfig{Regex: ".*", Action: "hashmod"}},
+		{"replace without target", RelabelConfig{Regex: ".*", Action: "replace"}},
+		{"invalid target label", RelabelConfig{Regex: ".*", TargetLabel: "peer-name", Action: "replace"}},
+	}
+	for _, test := range tests {
+		if _, err := NewRelabelGatherer(nil, []RelabelConfig{test.rule}); err == nil {
+			t.Errorf("%s: got no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"tailscale.com/client/local"
+	"time"
+)
+
+// scraper is a collector whose Collect logs the error scrape returns.
+type scraper interface {
+	prometheus.Collector
+	scrape(ch chan<- prometheus.Metric) error
+}
+
+// ScrapeCollector exports duration and outcome of every scrape of the wrapped collector, labeled collector=name.
+// Collectors which are not scrapers always succeed.
+type ScrapeCollector struct {
+	name            string
+	collector       prometheus.Collector
+	durationDesc    *prometheus.Desc
+	successDesc     *prometheus.Desc
+	lastSuccessDesc *prometheus.Desc
+	errorsDesc      *prometheus.Desc
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	running     atomic.Bool
+	errors      map[string]int
+}
+
+func NewScrapeCollector(name string, collector prometheus.Collector) *ScrapeCollector {
+	return newScrapeCollector("", name, collector)
+}
+
+// newScrapeCollector prefixes the scrape metrics with namespace, for collectors registered without it.
+func newScrapeCollector(namespace string, name string, collector prometheus.Collector) *ScrapeCollector {
+	labels := prometheus.Labels{"collector": name}
+	return &ScrapeCollector{
+		name:            name,
+		collector:       collector,
+		durationDesc:    prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "scrape_duration_seconds"), "Duration of the collector's last scrape.", nil, labels),
+		successDesc:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "scrape_success"), "Whether the collector's last scrape succeeded.", nil, labels),
+		lastSuccessDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "last_scrape_success_timestamp_seconds"), "When the collector last scraped successfully.", nil, labels),
+		errorsDesc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "scrape_errors_total"), "Number of failed scrapes of the collector by cause.", []string{"cause"}, labels),
+		errors:          map[string]int{},
+	}
+}
+
+func (collector *ScrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.collector.Describe(ch)
+	ch <- collector.durationDesc
+	ch <- collector.successDesc
+	ch <- collector.lastSuccessDesc
+	ch <- collector.errorsDesc
+}
+
+// SetScrapeDeadlines bounds every scrape by deadline, or by the deadline of its collector name in overrides.
+// Zero is no deadline. The registry runs the collectors concurrently, a deadline keeps a slow one from delaying the response.
+func SetScrapeDeadlines(deadline time.Duration, overrides map[string]time.Duration) {
+	scrapeDeadline = func(name string) time.Duration {
+		if override, ok := overrides[name]; ok {
+			return override
+		}
+		return deadline
+	}
+}
+
+var scrapeDeadline = func(string) time.Duration { return 0 }
+
+func (collector *ScrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	var err error
+	if deadline := scrapeDeadline(collector.name); deadline > 0 {
+		err = collector.collectWithin(ch, deadline)
+	} else {
+		err = collector.collect(ch)
+	}
+	duration := time.Since(start)
+	if err != nil {
+		slog.Error("scrape failed", "collector", collector.name, "err", err)
+	}
+	slog.Debug("scrape finished", "collector", collector.name, "duration", duration)
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if err == nil {
+		collector.lastSuccess = time.Now()
+	} else {
+		collector.errors[errorCause(err)]++
+	}
+	ch <- prometheus.MustNewConstMetric(collector.durationDesc, prometheus.GaugeValue, duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(collector.successDesc, prometheus.GaugeValue, boolToFloat(err == nil))
+	if !collector.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.lastSuccessDesc, prometheus.GaugeValue, float64(collector.lastSuccess.Unix()))
+	}
+	for _, cause := range errorCauses {
+		ch <- prometheus.MustNewConstMetric(collector.errorsDesc, prometheus.CounterValue, float64(collector.errors[cause]), cause)
+	}
+}
+
+// errorCauses are exported from the first scrape on, so alerts on increase() see the first failure.
+var errorCauses = []string{"exec_not_found", "timeout", "json_parse", "localapi_unreachable", "permission_denied", "other"}
+
+// errorCause classifies a scrape error for on-call triage.
+func errorCause(err error) string {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var opError *net.OpError
+	var pathError *os.PathError
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, exec.ErrDot):
+		return "exec_not_found"
+	case errors.As(err, &pathError) && pathError.Op == "fork/exec" && errors.Is(pathError.Err, os.ErrNotExist):
+		// a binary given by path
+		return "exec_not_found"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &syntaxError), errors.As(err, &typeError), errors.Is(err, io.ErrUnexpectedEOF):
+		return "json_parse"
+	case errors.Is(err, os.ErrPermission), local.IsAccessDeniedError(err):
+		return "permission_denied"
+	case errors.As(err, &opError) && isLocalAPI(opError):
+		return "localapi_unreachable"
+	default:
+		return "other"
+	}
+}
+
+func (collector *ScrapeCollector) collect(ch chan<- prometheus.Metric) error {
+	if scraper, ok := collector.collector.(scraper); ok {
+		return scraper.scrape(ch)
+	}
+	collector.collector.Collect(ch)
+	return nil
+}
+
+// collectWithin forwards the metrics of a scrape until deadline, then gives up on the rest.
+// A scrape still running from an earlier deadline is not started again.
+func (collector *ScrapeCollector) collectWithin(ch chan<- prometheus.Metric, deadline time.Duration) error {
+	if !collector.running.CompareAndSwap(false, true) {
+		return fmt.Errorf("previous scrape is still running after the deadline")
+	}
+	metrics := make(chan prometheus.Metric, 64)
+	result := make(chan error, 1)
+	go func() {
+		defer collector.running.Store(false)
+		result <- collector.collect(metrics)
+		close(metrics)
+	}()
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	for {
+		select {
+		case metric, ok := <-metrics:
+			if !ok {
+				return <-result
+			}
+			ch <- metric
+		case <-timer.C:
+			// let the scrape finish without blocking, its late metrics are dropped
+			go func() {
+				for range metrics {
+				}
+			}()
+			return fmt.Errorf("deadline of %s exceeded, exported partial results: %w", deadline, context.DeadlineExceeded)
+		}
+	}
+}
+
+// isLocalAPI reports whether a failed dial was to a unix socket or a loopback port, as the LocalAPI listens on.
+func isLocalAPI(opError *net.OpError) bool {
+	if opError.Net == "unix" {
+		return true
+	}
+	if opError.Addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(opError.Addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
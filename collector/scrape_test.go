@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+// uncheckedCollector describes nothing, its series are only known when collecting, as for UserMetricsCollector.
+type uncheckedCollector struct{}
+
+func (uncheckedCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (uncheckedCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("magicsock_recv_bytes", "", nil, nil), prometheus.CounterValue, 5)
+}
+
+func TestMustRegisterRawScrape(t *testing.T) {
+	registry := NewSelectableRegistry()
+	registry.MustRegisterRawScrape(registry, "tailscale", "usermetrics", uncheckedCollector{})
+	series := gatherSeries(t, registry)
+	if series["magicsock_recv_bytes"][""] != 5 {
+		t.Errorf("got %v, want the undescribed series without namespace", series["magicsock_recv_bytes"])
+	}
+	if series["tailscale_exporter_scrape_success"]["collector=usermetrics,"] != 1 {
+		t.Errorf("got %v, want the scrape metrics with namespace", series["tailscale_exporter_scrape_success"])
+	}
+}
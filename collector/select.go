@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"slices"
+	"sync"
+)
+
+// SelectableRegistry is a Registry which can also gather only some of its ScrapeCollectors by name,
+// for /metrics?collect[]=peers. Collectors without a name, e.g. build info and runtime metrics, are always gathered.
+// Collectors are named by registering them with MustRegisterScrape, at startup from a single goroutine.
+type SelectableRegistry struct {
+	*prometheus.Registry
+
+	mu         sync.Mutex
+	collectors []namedCollector
+	// naming is the name of the collector MustRegisterScrape is registering, empty otherwise
+	naming string
+}
+
+type namedCollector struct {
+	name      string
+	collector prometheus.Collector
+}
+
+func NewSelectableRegistry() *SelectableRegistry {
+	return &SelectableRegistry{Registry: prometheus.NewRegistry()}
+}
+
+// Register also remembers c, as the wrapping registerers pass it, with namespace and constant labels applied.
+func (registry *SelectableRegistry) Register(c prometheus.Collector) error {
+	if err := registry.Registry.Register(c); err != nil {
+		return err
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, namedCollector{name: registry.naming, collector: c})
+	return nil
+}
+
+// MustRegisterScrape registers NewScrapeCollector(name, c) with registerer, which wraps this registry,
+// and remembers the wrapped collector under name. The wrapping registerers hide which collector they pass on.
+func (registry *SelectableRegistry) MustRegisterScrape(registerer prometheus.Registerer, name string, c prometheus.Collector) {
+	registry.mustRegisterScrape(registerer, name, NewScrapeCollector(name, c))
+}
+
+// MustRegisterRawScrape is MustRegisterScrape for a registerer without the metrics namespace, as c names its
+// series itself. The scrape metrics get namespace, so they are named like the ones of the other collectors.
+func (registry *SelectableRegistry) MustRegisterRawScrape(rawRegisterer prometheus.Registerer, namespace string, name string, c prometheus.Collector) {
+	registry.mustRegisterScrape(rawRegisterer, name, newScrapeCollector(namespace, name, c))
+}
+
+func (registry *SelectableRegistry) mustRegisterScrape(registerer prometheus.Registerer, name string, c *ScrapeCollector) {
+	registry.mu.Lock()
+	registry.naming = name
+	registry.mu.Unlock()
+	defer func() {
+		registry.mu.Lock()
+		registry.naming = ""
+		registry.mu.Unlock()
+	}()
+	registerer.MustRegister(c)
+}
+
+func (registry *SelectableRegistry) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (registry *SelectableRegistry) Unregister(c prometheus.Collector) bool {
+	if !registry.Registry.Unregister(c) {
+		return false
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = slices.DeleteFunc(registry.collectors, func(named namedCollector) bool { return named.collector == c })
+	return true
+}
+
+// Names returns the sorted names of the registered ScrapeCollectors.
+func (registry *SelectableRegistry) Names() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	var names []string
+	for _, named := range registry.collectors {
+		if named.name != "" && !slices.Contains(names, named.name) {
+			names = append(names, named.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Select returns a gatherer of the collectors named in names and those without a name. Unknown names are an error.
+func (registry *SelectableRegistry) Select(names []string) (prometheus.Gatherer, error) {
+	known := registry.Names()
+	for _, name := range names {
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown collector %q, known are %v", name, known)
+		}
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	selected := prometheus.NewRegistry()
+	for _, named := range registry.collectors {
+		if named.name != "" && !slices.Contains(names, named.name) {
+			continue
+		}
+		if err := selected.Register(named.collector); err != nil {
+			return nil, fmt.Errorf("error on register collector %q: %w", named.name, err)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net"
+	"strconv"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"time"
+)
+
+var (
+	ServeHandlersDesc      = prometheus.NewDesc("serve_handlers", "Number of tailscale serve handlers, TCP forwards included.", nil, nil)
+	ServeHandlerInfoDesc   = prometheus.NewDesc("serve_handler_info", "tailscale serve handler, always 1. type is proxy, path, text, redirect or tcp.", []string{"host", "port", "path", "type", "target", "funnel"}, nil)
+	ServeFunnelEnabledDesc = prometheus.NewDesc("serve_funnel_enabled", "Whether Funnel exposes host:port to the internet.", []string{"host", "port"}, nil)
+)
+
+// ServeCollector exports the serve and Funnel config read from LocalAPI, foreground sessions included.
+type ServeCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *ServeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ServeHandlersDesc
+	ch <- ServeHandlerInfoDesc
+	ch <- ServeFunnelEnabledDesc
+}
+
+func (collector *ServeCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *ServeCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	serveConfig, err := collector.LocalClient.GetServeConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get serve config: %w", err)
+	}
+	if serveConfig == nil {
+		serveConfig = &ipn.ServeConfig{}
+	}
+	configs := []*ipn.ServeConfig{serveConfig}
+	for _, foreground := range serveConfig.Foreground {
+		configs = append(configs, foreground)
+	}
+	handlers := 0
+	for _, config := range configs {
+		funnelOnPort := func(port string) bool {
+			for hostPort, allowed := range config.AllowFunnel {
+				_, funnelPort, _ := net.SplitHostPort(string(hostPort))
+				if allowed && funnelPort == port {
+					return true
+				}
+			}
+			return false
+		}
+		for hostPort, allowed := range config.AllowFunnel {
+			host, port, _ := net.SplitHostPort(string(hostPort))
+			ch <- prometheus.MustNewConstMetric(ServeFunnelEnabledDesc, prometheus.GaugeValue, boolToFloat(allowed), host, port)
+		}
+		for hostPort, web := range config.Web {
+			host, port, _ := net.SplitHostPort(string(hostPort))
+			for path, handler := range web.Handlers {
+				handlerType, target := "proxy", handler.Proxy
+				switch {
+				case handler.Path != "":
+					handlerType, target = "path", handler.Path
+				case handler.Text != "":
+					handlerType, target = "text", ""
+				case handler.Redirect != "":
+					handlerType, target = "redirect", handler.Redirect
+				}
+				handlers++
+				ch <- prometheus.MustNewConstMetric(ServeHandlerInfoDesc, prometheus.GaugeValue, 1, host, port, path, handlerType, target, strconv.FormatBool(config.AllowFunnel[hostPort]))
+			}
+		}
+		for port, handler := range config.TCP {
+			if handler.TCPForward == "" {
+				// HTTP(S) handled by config.Web above
+				continue
+			}
+			handlers++
+			portString := strconv.Itoa(int(port))
+			ch <- prometheus.MustNewConstMetric(ServeHandlerInfoDesc, prometheus.GaugeValue, 1, "", portString, "", "tcp", handler.TCPForward, strconv.FormatBool(funnelOnPort(portString)))
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ServeHandlersDesc, prometheus.GaugeValue, float64(handlers))
+	return nil
+}
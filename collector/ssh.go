@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"log/slog"
+	"tailscale.com/client/local"
+	"time"
+)
+
+var (
+	SSHEnabledDesc          = prometheus.NewDesc("ssh_enabled", "Whether Tailscale SSH server is enabled (--ssh).", nil, nil)
+	SSHActiveSessionsDesc   = prometheus.NewDesc("ssh_active_sessions", "Number of open Tailscale SSH sessions.", nil, nil)
+	SSHConnectionsDesc      = prometheus.NewDesc("ssh_connections_total", "Number of incoming Tailscale SSH connections since tailscaled started.", nil, nil)
+	SSHSFTPSessionsDesc     = prometheus.NewDesc("ssh_sftp_sessions_total", "Number of Tailscale SSH SFTP sessions since tailscaled started.", nil, nil)
+	SSHPolicyDecisionsDesc  = prometheus.NewDesc("ssh_policy_decisions_total", "Number of final SSH policy decisions since tailscaled started, by result: accept or reject.", []string{"result"}, nil)
+	SSHPolicyChangeKickDesc = prometheus.NewDesc("ssh_policy_change_kicks_total", "Number of Tailscale SSH sessions closed because a policy change no longer allowed them.", nil, nil)
+)
+
+// sshDaemonMetrics maps the client metrics of tailscaled's SSH server to the exported series.
+var sshDaemonMetrics = map[string]struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	labels    []string
+}{
+	"ssh_active_sessions":       {SSHActiveSessionsDesc, prometheus.GaugeValue, nil},
+	"ssh_incoming_connections":  {SSHConnectionsDesc, prometheus.CounterValue, nil},
+	"ssh_sftp_sessions":         {SSHSFTPSessionsDesc, prometheus.CounterValue, nil},
+	"ssh_terminalaction_accept": {SSHPolicyDecisionsDesc, prometheus.CounterValue, []string{"accept"}},
+	"ssh_terminalaction_reject": {SSHPolicyDecisionsDesc, prometheus.CounterValue, []string{"reject"}},
+	"ssh_policy_change_kick":    {SSHPolicyChangeKickDesc, prometheus.CounterValue, nil},
+}
+
+// SSHCollector exports whether Tailscale SSH is enabled and its session counters, read from LocalAPI.
+// The counters come from the daemon metrics, which need LocalAPI write access and only exist once the SSH server ran.
+type SSHCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *SSHCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- SSHEnabledDesc
+	ch <- SSHActiveSessionsDesc
+	ch <- SSHConnectionsDesc
+	ch <- SSHSFTPSessionsDesc
+	ch <- SSHPolicyDecisionsDesc
+	ch <- SSHPolicyChangeKickDesc
+}
+
+func (collector *SSHCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *SSHCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	prefs, err := collector.LocalClient.GetPrefs(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get prefs: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(SSHEnabledDesc, prometheus.GaugeValue, boolToFloat(prefs.RunSSH))
+	if !prefs.RunSSH {
+		return nil
+	}
+	content, err := collector.LocalClient.DaemonMetrics(ctx)
+	if local.IsAccessDeniedError(err) {
+		slog.Debug("no access to daemon metrics, skipping ssh session counters", "err", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error on get daemon metrics: %w", err)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("error on parse daemon metrics: %w", err)
+	}
+	for name, family := range families {
+		series, ok := sshDaemonMetrics[name]
+		if !ok || len(family.Metric) == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(series.desc, series.valueType, metricValue(family.Metric[0]), series.labels...)
+	}
+	return nil
+}
+
+// metricValue returns the value of a parsed counter, gauge or untyped series.
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	default:
+		return metric.Untyped.GetValue()
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"sync"
+)
+
+var TailscaledStartTimeDesc = prometheus.NewDesc("tailscaled_start_time_seconds", "Start time of tailscaled since unix epoch, missing where procfs is not readable.", nil, nil)
+
+// TailscaledStartCollector exports when tailscaled started, so its restarts can be correlated with traffic drops.
+// It is best effort like the daemon collector but cheaper: the process is only searched again when it went away.
+type TailscaledStartCollector struct {
+	// ProcPath is /proc, or the one of the host when running in a container
+	ProcPath string
+
+	mu  sync.Mutex
+	pid int
+}
+
+func (collector *TailscaledStartCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- TailscaledStartTimeDesc
+}
+
+func (collector *TailscaledStartCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	fs, err := procfs.NewFS(collector.ProcPath)
+	if err != nil {
+		return
+	}
+	proc, err := fs.Proc(collector.pid)
+	if name, commErr := proc.Comm(); collector.pid == 0 || err != nil || commErr != nil || name != "tailscaled" {
+		if proc, err = findProc(collector.ProcPath, "tailscaled"); err != nil {
+			collector.pid = 0
+			return
+		}
+		collector.pid = proc.PID
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return
+	}
+	if startTime, err := stat.StartTime(); err == nil {
+		ch <- prometheus.MustNewConstMetric(TailscaledStartTimeDesc, prometheus.GaugeValue, startTime)
+	}
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"os"
+	"tailscale.com/client/local"
+	"time"
+)
+
+var (
+	DriveSharesDesc          = prometheus.NewDesc("drive_shares", "Number of configured Taildrive shares.", nil, nil)
+	DriveShareInfoDesc       = prometheus.NewDesc("drive_share_info", "Taildrive share, always 1. as is the user tailscaled serves the share as.", []string{"share", "path", "as"}, nil)
+	DriveShareAccessibleDesc = prometheus.NewDesc("drive_share_accessible", "Whether the shared directory exists and can be read by the exporter.", []string{"share"}, nil)
+)
+
+// TaildriveCollector exports Taildrive shares read from LocalAPI.
+type TaildriveCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *TaildriveCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- DriveSharesDesc
+	ch <- DriveShareInfoDesc
+	ch <- DriveShareAccessibleDesc
+}
+
+func (collector *TaildriveCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *TaildriveCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	shares, err := collector.LocalClient.DriveShareList(ctx)
+	if err != nil {
+		return fmt.Errorf("error on list taildrive shares: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(DriveSharesDesc, prometheus.GaugeValue, float64(len(shares)))
+	for _, share := range shares {
+		ch <- prometheus.MustNewConstMetric(DriveShareInfoDesc, prometheus.GaugeValue, 1, share.Name, share.Path, share.As)
+		ch <- prometheus.MustNewConstMetric(DriveShareAccessibleDesc, prometheus.GaugeValue, boolToFloat(dirReadable(share.Path)), share.Name)
+	}
+	return nil
+}
+
+func dirReadable(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	// io.EOF for an empty directory
+	return err == nil || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// AdminAPIDNS is the central DNS configuration of the tailnet.
+type AdminAPIDNS struct {
+	Nameservers []string
+	MagicDNS    bool
+	SearchPaths []string
+	// SplitDNS maps domains to their nameservers
+	SplitDNS map[string][]string
+}
+
+func (client *AdminAPIClient) GetDNS(ctx context.Context) (*AdminAPIDNS, error) {
+	nameservers := struct {
+		DNS []string `json:"dns"`
+	}{}
+	if err := client.get(ctx, "/dns/nameservers", &nameservers); err != nil {
+		return nil, err
+	}
+	preferences := struct {
+		MagicDNS bool `json:"magicDNS"`
+	}{}
+	if err := client.get(ctx, "/dns/preferences", &preferences); err != nil {
+		return nil, err
+	}
+	searchPaths := struct {
+		SearchPaths []string `json:"searchPaths"`
+	}{}
+	if err := client.get(ctx, "/dns/searchpaths", &searchPaths); err != nil {
+		return nil, err
+	}
+	splitDNS := map[string][]string{}
+	if err := client.get(ctx, "/dns/split-dns", &splitDNS); err != nil {
+		return nil, err
+	}
+	return &AdminAPIDNS{Nameservers: nameservers.DNS, MagicDNS: preferences.MagicDNS, SearchPaths: searchPaths.SearchPaths, SplitDNS: splitDNS}, nil
+}
+
+var (
+	TailnetDNSMagicDNSDesc     = prometheus.NewDesc("tailnet_dns_magicdns_enabled", "Whether MagicDNS is enabled in the tailnet DNS settings of the Admin API.", nil, nil)
+	TailnetDNSNameserversDesc  = prometheus.NewDesc("tailnet_dns_nameservers", "Number of global nameservers in the tailnet DNS settings.", nil, nil)
+	TailnetDNSNameserverDesc   = prometheus.NewDesc("tailnet_dns_nameserver_info", "Global nameserver of the tailnet DNS settings, always 1.", []string{"nameserver"}, nil)
+	TailnetDNSSplitDomainsDesc = prometheus.NewDesc("tailnet_dns_split_dns_domains", "Number of split DNS domains in the tailnet DNS settings.", nil, nil)
+	TailnetDNSSplitDomainDesc  = prometheus.NewDesc("tailnet_dns_split_dns_info", "Split DNS domain of the tailnet DNS settings with its nameservers, always 1.", []string{"domain", "nameservers"}, nil)
+	TailnetDNSSearchPathsDesc  = prometheus.NewDesc("tailnet_dns_search_paths", "Number of search paths in the tailnet DNS settings.", nil, nil)
+	TailnetDNSSearchPathDesc   = prometheus.NewDesc("tailnet_dns_search_path_info", "Search path of the tailnet DNS settings, always 1.", []string{"search_path"}, nil)
+)
+
+// TailnetDNSCollector exports the central DNS settings of the tailnet, the dns collector shows what this node received.
+type TailnetDNSCollector struct {
+	Client  *AdminAPIClient
+	Timeout time.Duration
+}
+
+func (collector *TailnetDNSCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- TailnetDNSMagicDNSDesc
+	ch <- TailnetDNSNameserversDesc
+	ch <- TailnetDNSNameserverDesc
+	ch <- TailnetDNSSplitDomainsDesc
+	ch <- TailnetDNSSplitDomainDesc
+	ch <- TailnetDNSSearchPathsDesc
+	ch <- TailnetDNSSearchPathDesc
+}
+
+func (collector *TailnetDNSCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *TailnetDNSCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	dns, err := collector.Client.GetDNS(ctx)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(TailnetDNSMagicDNSDesc, prometheus.GaugeValue, boolToFloat(dns.MagicDNS))
+	ch <- prometheus.MustNewConstMetric(TailnetDNSNameserversDesc, prometheus.GaugeValue, float64(len(dns.Nameservers)))
+	for _, nameserver := range dns.Nameservers {
+		ch <- prometheus.MustNewConstMetric(TailnetDNSNameserverDesc, prometheus.GaugeValue, 1, nameserver)
+	}
+	ch <- prometheus.MustNewConstMetric(TailnetDNSSplitDomainsDesc, prometheus.GaugeValue, float64(len(dns.SplitDNS)))
+	for domain, nameservers := range dns.SplitDNS {
+		ch <- prometheus.MustNewConstMetric(TailnetDNSSplitDomainDesc, prometheus.GaugeValue, 1, domain, strings.Join(nameservers, ","))
+	}
+	ch <- prometheus.MustNewConstMetric(TailnetDNSSearchPathsDesc, prometheus.GaugeValue, float64(len(dns.SearchPaths)))
+	for _, searchPath := range dns.SearchPaths {
+		ch <- prometheus.MustNewConstMetric(TailnetDNSSearchPathDesc, prometheus.GaugeValue, 1, searchPath)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"tailscale.com/client/local"
+	"time"
+)
+
+var (
+	TailnetLockEnabledDesc       = prometheus.NewDesc("tailnet_lock_enabled", "Whether tailnet lock is enabled.", nil, nil)
+	TailnetLockNodeSignedDesc    = prometheus.NewDesc("tailnet_lock_node_signed", "Whether the node key of this node is signed by a trusted key.", nil, nil)
+	TailnetLockTrustedKeysDesc   = prometheus.NewDesc("tailnet_lock_trusted_keys", "Number of trusted tailnet lock signing keys.", nil, nil)
+	TailnetLockFilteredPeersDesc = prometheus.NewDesc("tailnet_lock_filtered_peers", "Number of peers dropped from the netmap because their node key is not signed.", nil, nil)
+)
+
+// TailnetLockCollector exports `tailscale lock status` read from LocalAPI.
+type TailnetLockCollector struct {
+	LocalClient *local.Client
+	Timeout     time.Duration
+}
+
+func (collector *TailnetLockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- TailnetLockEnabledDesc
+	ch <- TailnetLockNodeSignedDesc
+	ch <- TailnetLockTrustedKeysDesc
+	ch <- TailnetLockFilteredPeersDesc
+}
+
+func (collector *TailnetLockCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *TailnetLockCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	status, err := collector.LocalClient.TailnetLockStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get tailnet lock status: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(TailnetLockEnabledDesc, prometheus.GaugeValue, boolToFloat(status.Enabled))
+	ch <- prometheus.MustNewConstMetric(TailnetLockNodeSignedDesc, prometheus.GaugeValue, boolToFloat(status.NodeKeySigned))
+	ch <- prometheus.MustNewConstMetric(TailnetLockTrustedKeysDesc, prometheus.GaugeValue, float64(len(status.TrustedKeys)))
+	ch <- prometheus.MustNewConstMetric(TailnetLockFilteredPeersDesc, prometheus.GaugeValue, float64(len(status.FilteredPeers)))
+	return nil
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+)
+
+// TransitionTracker counts peer online/offline and backend state changes between observed statuses.
+// Statuses are observed on every fetch, so with a status refresh interval or the IPN bus watcher
+// short outages are counted even if no scrape sees them.
+type TransitionTracker struct {
+	// AggregatePeers counts peer transitions without the peer labels
+	AggregatePeers bool
+
+	peerOnline   *prometheus.CounterVec
+	backendState *prometheus.CounterVec
+
+	mu         sync.Mutex
+	peerFilter PeerFilter
+	online     map[string]bool
+	backend    string
+}
+
+func NewTransitionTracker() *TransitionTracker {
+	return &TransitionTracker{
+		peerOnline: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peer_online_transitions_total",
+			Help: "Number of times a peer went online or offline, state is the new one.",
+		}, []string{"peer_id", "peer_name", "peer_ip", "state"}),
+		backendState: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backend_state_changes_total",
+			Help: "Number of tailscaled backend state changes, state is the new one.",
+		}, []string{"state"}),
+		online: map[string]bool{},
+	}
+}
+
+// SetPeerFilter replaces the filter of the tracked peers, as for PeerCollector. only_online is ignored,
+// it would hide every offline transition.
+func (tracker *TransitionTracker) SetPeerFilter(filter PeerFilter) {
+	if err := filter.Compile(); err != nil {
+		slog.Error("error on compile peer filter", "err", err)
+	}
+	filter.OnlyOnline = false
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.peerFilter = filter
+}
+
+// Track wraps provider to observe every fetched status.
+func (tracker *TransitionTracker) Track(provider tailscaleclient.StatusProvider) tailscaleclient.StatusProvider {
+	return tailscaleclient.StatusFunc(func(ctx context.Context) (*tailscaleclient.Status, error) {
+		status, err := provider.Status(ctx)
+		if err == nil {
+			tracker.Observe(status)
+		}
+		return status, err
+	})
+}
+
+// Observe counts the changes since the previous status. Observing the same status twice counts nothing.
+func (tracker *TransitionTracker) Observe(status *tailscaleclient.Status) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.backend != "" && tracker.backend != status.BackendState {
+		tracker.backendState.WithLabelValues(status.BackendState).Inc()
+	}
+	tracker.backend = status.BackendState
+
+	seen := map[string]bool{}
+	for _, peer := range status.Peer {
+		if !tracker.peerFilter.Match(&peer) {
+			continue
+		}
+		seen[peer.ID] = true
+		was, known := tracker.online[peer.ID]
+		if known && was != peer.Online {
+			state := "offline"
+			if peer.Online {
+				state = "online"
+			}
+			if tracker.AggregatePeers {
+				tracker.peerOnline.WithLabelValues("", "", "", state).Inc()
+			} else {
+				tracker.peerOnline.WithLabelValues(peer.ID, peer.HostName, firstIP(peer.TailscaleIPs), state).Inc()
+			}
+		}
+		tracker.online[peer.ID] = peer.Online
+	}
+	for id := range tracker.online {
+		if !seen[id] {
+			delete(tracker.online, id)
+			// departed or filtered out, ephemeral nodes would grow the series forever
+			if !tracker.AggregatePeers {
+				tracker.peerOnline.DeletePartialMatch(prometheus.Labels{"peer_id": id})
+			}
+		}
+	}
+}
+
+func (tracker *TransitionTracker) Describe(ch chan<- *prometheus.Desc) {
+	tracker.peerOnline.Describe(ch)
+	tracker.backendState.Describe(ch)
+}
+
+func (tracker *TransitionTracker) Collect(ch chan<- prometheus.Metric) {
+	tracker.peerOnline.Collect(ch)
+	tracker.backendState.Collect(ch)
+}
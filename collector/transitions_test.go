@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"maps"
+	"tailscale-exporter/tailscaleclient"
+	"testing"
+)
+
+// onlineStatus returns a running status with the peers by host name, ID and online state.
+func onlineStatus(backendState string, online map[string]bool) *tailscaleclient.Status {
+	status := &tailscaleclient.Status{BackendState: backendState, Peer: map[string]tailscaleclient.Peer{}}
+	for name, isOnline := range online {
+		status.Peer["k-"+name] = tailscaleclient.Peer{ID: "id-" + name, HostName: name, Online: isOnline}
+	}
+	return status
+}
+
+func TestTransitionTracker(t *testing.T) {
+	tracker := NewTransitionTracker()
+	tracker.SetPeerFilter(PeerFilter{ExcludeRegex: "ci-.*", OnlyOnline: true})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(tracker)
+
+	tracker.Observe(onlineStatus("Running", map[string]bool{"a": true, "b": true, "ci-1": true}))
+	// the same status twice counts nothing
+	tracker.Observe(onlineStatus("Running", map[string]bool{"a": true, "b": true, "ci-1": true}))
+	// offline peers are tracked although only_online is set, filtered out ones are not
+	tracker.Observe(onlineStatus("Running", map[string]bool{"a": false, "b": true, "ci-1": false}))
+	tracker.Observe(onlineStatus("Starting", map[string]bool{"a": true, "b": false}))
+	series := gatherSeries(t, registry)
+	want := map[string]map[string]float64{
+		"peer_online_transitions_total": {
+			"peer_id=id-a,peer_ip=,peer_name=a,state=offline,": 1,
+			"peer_id=id-a,peer_ip=,peer_name=a,state=online,":  1,
+			"peer_id=id-b,peer_ip=,peer_name=b,state=offline,": 1,
+		},
+		"backend_state_changes_total": {"state=Starting,": 1},
+	}
+	for family, values := range want {
+		if !maps.Equal(series[family], values) {
+			t.Errorf("%s: got %v, want %v", family, series[family], values)
+		}
+	}
+
+	// a departed peer loses its series
+	tracker.Observe(onlineStatus("Starting", map[string]bool{"b": false}))
+	series = gatherSeries(t, registry)
+	if values := series["peer_online_transitions_total"]; len(values) != 1 {
+		t.Errorf("got %v, want only the series of b", values)
+	}
+}
@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"log/slog"
+	"slices"
+	"strings"
+	"tailscale.com/client/local"
+	"time"
+)
+
+// UserMetricsCollector re-exposes the user metrics of tailscaled (`tailscale metrics print`),
+// magicsock, DERP and packet filter internals, with Prefix prepended to their names.
+// It is registered without the metrics namespace, with an empty Prefix the series keep the names of tailscaled.
+type UserMetricsCollector struct {
+	LocalClient *local.Client
+	Prefix      string
+	Timeout     time.Duration
+}
+
+// Describe sends nothing, the series are only known after fetching them.
+func (collector *UserMetricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (collector *UserMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *UserMetricsCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	content, err := collector.LocalClient.UserMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get user metrics: %w", err)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("error on parse user metrics: %w", err)
+	}
+	for name, family := range families {
+		desc := prometheus.NewDesc(collector.Prefix+name, family.GetHelp(), nil, nil)
+		for _, metric := range family.Metric {
+			ch <- &passthroughMetric{desc: desc, metric: metric}
+		}
+	}
+	return nil
+}
+
+// passthroughMetric is an already gathered series, written out as is.
+type passthroughMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (metric *passthroughMetric) Desc() *prometheus.Desc {
+	return metric.desc
+}
+
+func (metric *passthroughMetric) Write(out *dto.Metric) error {
+	out.Label = slices.SortedFunc(slices.Values(metric.metric.Label), func(a, b *dto.LabelPair) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+	out.Counter = metric.metric.Counter
+	out.Gauge = metric.metric.Gauge
+	out.Untyped = metric.metric.Untyped
+	out.Summary = metric.metric.Summary
+	out.Histogram = metric.metric.Histogram
+	out.TimestampMs = metric.metric.TimestampMs
+	return nil
+}
@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+var (
+	VersionInfoDesc     = prometheus.NewDesc("version_info", "Versions of the tailscale CLI and of the tailscaled it talks to.", []string{"client_version", "daemon_version"}, nil)
+	VersionMismatchDesc = prometheus.NewDesc("version_mismatch", "Whether the tailscale CLI and tailscaled versions differ, e.g. after a partial upgrade.", nil, nil)
+)
+
+// VersionCollector compares the version of the tailscale CLI with the one of tailscaled.
+type VersionCollector struct {
+	CLI     tailscaleclient.CLI
+	Timeout time.Duration
+}
+
+func (collector *VersionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- VersionInfoDesc
+	ch <- VersionMismatchDesc
+}
+
+func (collector *VersionCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := collector.scrape(ch); err != nil {
+		slog.Error("collection failed", "err", err)
+	}
+}
+
+func (collector *VersionCollector) scrape(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.Timeout)
+	defer cancel()
+	info, err := collector.CLI.Version(ctx)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(VersionInfoDesc, prometheus.GaugeValue, 1, info.Long, info.DaemonLong)
+	// the long versions include the git commits, so differently built binaries of one release also mismatch
+	ch <- prometheus.MustNewConstMetric(VersionMismatchDesc, prometheus.GaugeValue, boolToFloat(info.Long != info.DaemonLong))
+	return nil
+}
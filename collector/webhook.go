@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const webhookSignatureHeader = "Tailscale-Webhook-Signature"
+
+// webhookMaxAge rejects replayed deliveries with old signatures.
+const webhookMaxAge = 5 * time.Minute
+
+type WebhookEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Version   int       `json:"version"`
+	Type      string    `json:"type"`
+	Tailnet   string    `json:"tailnet"`
+	Message   string    `json:"message"`
+}
+
+// WebhookReceiver accepts signed Tailscale webhook deliveries and counts events by type.
+type WebhookReceiver struct {
+	Secret string
+
+	events    *prometheus.CounterVec
+	lastEvent *prometheus.GaugeVec
+	invalid   prometheus.Counter
+}
+
+func NewWebhookReceiver(secret string) *WebhookReceiver {
+	return &WebhookReceiver{
+		Secret: secret,
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_events_total",
+			Help: "Number of received Tailscale webhook events.",
+		}, []string{"type", "tailnet"}),
+		lastEvent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "webhook_last_event_timestamp_seconds",
+			Help: "Time of the last received Tailscale webhook event.",
+		}, []string{"type", "tailnet"}),
+		invalid: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "webhook_invalid_requests_total",
+			Help: "Number of rejected webhook deliveries (bad signature or payload).",
+		}),
+	}
+}
+
+func (receiver *WebhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := receiver.verify(r.Header.Get(webhookSignatureHeader), body, time.Now()); err != nil {
+		receiver.invalid.Inc()
+		slog.Warn("webhook rejected", "err", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	events := []WebhookEvent{}
+	if err := json.Unmarshal(body, &events); err != nil {
+		receiver.invalid.Inc()
+		slog.Warn("webhook rejected: error on unmarshal", "err", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		receiver.events.WithLabelValues(event.Type, event.Tailnet).Inc()
+		receiver.lastEvent.WithLabelValues(event.Type, event.Tailnet).Set(float64(event.Timestamp.Unix()))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verify checks header "t=<unix>,v1=<hex>" where v1 is HMAC-SHA256 of "<t>.<body>".
+func (receiver *WebhookReceiver) verify(header string, body []byte, now time.Time) error {
+	timestamp, signature := "", ""
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("malformed %s header", webhookSignatureHeader)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed timestamp: %w", err)
+	}
+	// a future timestamp would otherwise stay valid forever
+	if age := now.Sub(time.Unix(unix, 0)); age > webhookMaxAge || age < -webhookMaxAge {
+		return fmt.Errorf("signature timestamp is outside the replay window")
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(receiver.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func (receiver *WebhookReceiver) Describe(ch chan<- *prometheus.Desc) {
+	receiver.events.Describe(ch)
+	receiver.lastEvent.Describe(ch)
+	receiver.invalid.Describe(ch)
+}
+
+func (receiver *WebhookReceiver) Collect(ch chan<- prometheus.Metric) {
+	receiver.events.Collect(ch)
+	receiver.lastEvent.Collect(ch)
+	receiver.invalid.Collect(ch)
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookReceiverVerify(t *testing.T) {
+	receiver := NewWebhookReceiver("secret")
+	body := []byte(`[{"type":"nodeCreated"}]`)
+	now := time.Unix(1700000000, 0)
+	sign := func(secret string, at time.Time) string {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{"valid", sign("secret", now), true},
+		{"valid within the window", sign("secret", now.Add(-webhookMaxAge+time.Second)), true},
+		{"bad mac", sign("other", now), false},
+		{"stale timestamp", sign("secret", now.Add(-webhookMaxAge-time.Second)), false},
+		{"future timestamp", sign("secret", now.Add(webhookMaxAge+time.Second)), false},
+		{"empty header", "", false},
+		{"missing timestamp", "v1=00", false},
+		{"missing signature", "t=1700000000", false},
+		{"non numeric timestamp", "t=now,v1=00", false},
+		{"non hex signature", "t=1700000000,v1=zz", false},
+	}
+	for _, test := range tests {
+		err := receiver.verify(test.header, body, now)
+		if test.valid && err != nil {
+			t.Errorf("%s: got %v, want valid", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: got valid, want an error", test.name)
+		}
+	}
+}
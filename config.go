@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/alecthomas/kingpin/v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sync"
+	"syscall"
+	"tailscale-exporter/collector"
+	"tailscale-exporter/server"
+	"time"
+)
+
+// Config holds all exporter settings. Command line flags fill it first,
+// then keys present in --config.file override them.
+type Config struct {
+	Metrics     MetricsConfig             `yaml:"metrics"`
+	Labels      LabelsConfig              `yaml:"labels"`
+	Relabel     []collector.RelabelConfig `yaml:"relabel"`
+	Tailscale   TailscaleConfig           `yaml:"tailscale"`
+	Collectors  CollectorsConfig          `yaml:"collectors"`
+	Peers       collector.PeerFilter      `yaml:"peers"`
+	Status      StatusConfig              `yaml:"status"`
+	Netcheck    NetcheckConfig            `yaml:"netcheck"`
+	Probe       collector.ProbeConfig     `yaml:"probe"`
+	Web         server.WebConfig          `yaml:"web"`
+	Access      server.AccessPolicy       `yaml:"access"`
+	Auth        server.AuthConfig         `yaml:"auth"`
+	Tsnet       TsnetConfig               `yaml:"tsnet"`
+	Federate    FederateConfig            `yaml:"federate"`
+	Serve       server.ServeConfig        `yaml:"serve"`
+	AdminAPI    AdminAPIConfig            `yaml:"admin_api"`
+	Headscale   HeadscaleConfig           `yaml:"headscale"`
+	Webhook     WebhookConfig             `yaml:"webhook"`
+	Debug       DebugConfig               `yaml:"debug"`
+	Output      OutputConfig              `yaml:"output"`
+	OTLP        server.OTLPConfig         `yaml:"otlp"`
+	RemoteWrite server.RemoteWriteConfig  `yaml:"remote_write"`
+	Push        server.PushgatewayConfig  `yaml:"push"`
+	StatsD      server.StatsDConfig       `yaml:"statsd"`
+	SSH         SSHConfig                 `yaml:"ssh"`
+}
+
+type MetricsConfig struct {
+	Namespace string `yaml:"namespace"`
+	// AggregateOnly exports totals over the peers instead of series per peer
+	AggregateOnly bool `yaml:"aggregate_only"`
+}
+
+// LabelsConfig hides host names, DNS names, IPs and user names in every output.
+type LabelsConfig struct {
+	Redact   bool   `yaml:"redact"`
+	Hash     bool   `yaml:"hash"`
+	HashSalt string `yaml:"hash_salt"`
+}
+
+// TailscaleConfig locates the tailscale CLI and the tailscaled LocalAPI socket.
+// An empty socket uses the platform default.
+type TailscaleConfig struct {
+	Binary string `yaml:"binary"`
+	Socket string `yaml:"socket"`
+	// Timeout bounds status and LocalAPI calls, status retries included
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	// ExecConcurrency and ExecMinInterval limit the tailscale CLI processes of all collectors and instances, 0 is unlimited
+	ExecConcurrency int           `yaml:"exec_concurrency"`
+	ExecMinInterval time.Duration `yaml:"exec_min_interval"`
+	// Instances maps instance_name to the socket of additional tailscaled to monitor.
+	// When set, all series carry instance_name, the one of the primary tailscaled is InstanceName.
+	Instances    map[string]string `yaml:"instances"`
+	InstanceName string            `yaml:"instance_name"`
+	// StatusFile replays a recorded status instead of asking tailscaled, re-read on every fetch with StatusFileReload
+	StatusFile       string `yaml:"status_file"`
+	StatusFileReload bool   `yaml:"status_file_reload"`
+	// MacOSGUI reads status over the LocalAPI port of the macOS GUI client, found in the sameuserproof file of SameUserProofDir
+	MacOSGUI         bool   `yaml:"macos_gui"`
+	SameUserProofDir string `yaml:"sameuserproof_dir"`
+}
+
+// CollectorsConfig enables collectors independently, set by --[no-]collector.<name>.
+// The netcheck collector is enabled by netcheck.enabled, Admin API and Headscale also need credentials.
+type CollectorsConfig struct {
+	Go        bool `yaml:"go"`
+	Process   bool `yaml:"process"`
+	Peers     bool `yaml:"peers"`
+	Probes    bool `yaml:"probes"`
+	AdminAPI  bool `yaml:"admin_api"`
+	Policy    bool `yaml:"policy"`
+	Keys      bool `yaml:"keys"`
+	Headscale bool `yaml:"headscale"`
+	Webhook   bool `yaml:"webhook"`
+	Prefs     bool `yaml:"prefs"`
+	SSH       bool `yaml:"ssh"`
+	RouteInfo bool `yaml:"route_info"`
+	// UserTraffic sums the peer byte counters per owning user
+	UserTraffic bool `yaml:"user_traffic"`
+	// TagTraffic sums them per peer tag
+	TagTraffic bool `yaml:"tag_traffic"`
+	// KeyExpiryWindow counts peers whose node key expires within it
+	KeyExpiryWindow time.Duration `yaml:"key_expiry_window"`
+	// PeerGracePeriod keeps exporting peers gone from the netmap for this long, 0 drops them at once
+	PeerGracePeriod time.Duration `yaml:"peer_grace_period"`
+	// PeerRateInterval exports peer byte rates computed over at least this interval, 0 disables them
+	PeerRateInterval time.Duration `yaml:"peer_rate_interval"`
+	// KeysExpiryWindow counts auth and API keys expiring within it
+	KeysExpiryWindow time.Duration `yaml:"keys_expiry_window"`
+	// Accumulate exports peer byte counters adjusted for resets, persisted to AccumulateFile if set
+	Accumulate     bool   `yaml:"accumulate"`
+	AccumulateFile string `yaml:"accumulate_file"`
+	Serve          bool   `yaml:"serve"`
+	TailnetLock    bool   `yaml:"tailnet_lock"`
+	Taildrop       bool   `yaml:"taildrop"`
+	Taildrive      bool   `yaml:"taildrive"`
+	Cert           bool   `yaml:"cert"`
+	DNS            bool   `yaml:"dns"`
+	TailnetDNS     bool   `yaml:"tailnet_dns"`
+	Forwarding     bool   `yaml:"forwarding"`
+	Version        bool   `yaml:"version"`
+	// CertDir holds the <domain>.crt files of the cert collector
+	CertDir     string `yaml:"cert_dir"`
+	UserMetrics bool   `yaml:"usermetrics"`
+	// UserMetricsPrefix is prepended to the names of tailscaled, the metrics namespace is not
+	UserMetricsPrefix string `yaml:"usermetrics_prefix"`
+	NetDev            bool   `yaml:"netdev"`
+	NetDevInterface   string `yaml:"netdev_interface"`
+	NetDevPath        string `yaml:"netdev_path"`
+	Daemon            bool   `yaml:"daemon"`
+	DaemonProcPath    string `yaml:"daemon_proc_path"`
+	TailscaledStart   bool   `yaml:"tailscaled_start"`
+	// ScrapeDeadline bounds every scrape, ScrapeDeadlines the scrapes of single collectors by name, 0 is no deadline
+	ScrapeDeadline  time.Duration            `yaml:"scrape_deadline"`
+	ScrapeDeadlines map[string]time.Duration `yaml:"scrape_deadlines"`
+}
+
+type StatusConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	MaxAge          time.Duration `yaml:"max_age"`
+	WatchIPNBus     bool          `yaml:"watch_ipn_bus"`
+	ResyncInterval  time.Duration `yaml:"resync_interval"`
+}
+
+type NetcheckConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type TsnetConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Hostname  string        `yaml:"hostname"`
+	StateDir  string        `yaml:"state_dir"`
+	UpTimeout time.Duration `yaml:"up_timeout"`
+}
+
+// FederateConfig selects the peer exporters merged on /federate.
+type FederateConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Tags    []string      `yaml:"tags"`
+	Port    string        `yaml:"port"`
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type AdminAPIConfig struct {
+	BaseURL           string        `yaml:"base_url"`
+	Tailnet           string        `yaml:"tailnet"`
+	Key               string        `yaml:"key"`
+	OAuthClientID     string        `yaml:"oauth_client_id"`
+	OAuthClientSecret string        `yaml:"oauth_client_secret"`
+	Timeout           time.Duration `yaml:"timeout"`
+	// PostureAttributes are exported per device, e.g. custom:diskEncryption or node:os
+	PostureAttributes []string `yaml:"posture_attributes"`
+}
+
+type HeadscaleConfig struct {
+	URL     string        `yaml:"url"`
+	APIKey  string        `yaml:"api_key"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type WebhookConfig struct {
+	Secret string `yaml:"secret"`
+	// ListenAddress serves /webhook outside the metrics listener, it must be reachable from the internet
+	ListenAddress string `yaml:"listen_address"`
+}
+
+type DebugConfig struct {
+	EnablePprof   bool   `yaml:"enable_pprof"`
+	ListenAddress string `yaml:"listen_address"`
+	// EnableStatus serves /debug/status on the metrics listener, behind access control and auth
+	EnableStatus bool `yaml:"enable_status"`
+	StatusRedact bool `yaml:"status_redact"`
+}
+
+type OutputConfig struct {
+	TextfileDir      string        `yaml:"textfile_dir"`
+	TextfileInterval time.Duration `yaml:"textfile_interval"`
+	TextfileOnly     bool          `yaml:"textfile_only"`
+	FileSDPath       string        `yaml:"file_sd_path"`
+	FileSDInterval   time.Duration `yaml:"file_sd_interval"`
+	FileSDPort       string        `yaml:"file_sd_port"`
+	FileSDTags       []string      `yaml:"file_sd_tags"`
+}
+
+// SSHConfig selects the remote hosts whose tailscaled is collected over SSH, for appliances without the exporter.
+type SSHConfig struct {
+	// Hosts maps remote_host to the address of the host, port 22 if it has none
+	Hosts          map[string]string `yaml:"hosts"`
+	User           string            `yaml:"user"`
+	KeyFile        string            `yaml:"key_file"`
+	KnownHostsFile string            `yaml:"known_hosts_file"`
+	Command        string            `yaml:"command"`
+	Timeout        time.Duration     `yaml:"timeout"`
+}
+
+func RegisterFlags(app *kingpin.Application, cfg *Config) {
+	app.Flag("metrics.namespace", "Prefix of all exported tailscale metric names, e.g. to tell several exporters apart. Empty for none.").Default("tailscale").StringVar(&cfg.Metrics.Namespace)
+	app.Flag("metrics.aggregate-only", "Skip the series per peer and per user, export only totals over the peers: traffic, peer, online and direct counts.").Default("false").BoolVar(&cfg.Metrics.AggregateOnly)
+	app.Flag("labels.redact", "Drop labels holding host names, DNS names, IPs, user names and node IDs and keys. Series only differing in them are summed, or dropped where summing means nothing, e.g. timestamps and info series.").Default("false").BoolVar(&cfg.Labels.Redact)
+	app.Flag("labels.hash", "Replace the values of labels holding host names, DNS names, IPs, user names and node IDs and keys with stable hashes.").Default("false").BoolVar(&cfg.Labels.Hash)
+	app.Flag("labels.hash-salt", "Salt of the label hashes, so IPs can not be recovered by hashing the whole address range. Required with labels.hash, keep it secret and stable.").Envar("LABELS_HASH_SALT").Default("").StringVar(&cfg.Labels.HashSalt)
+	app.Flag("tailscale.binary", "Path or name of the tailscale CLI.").Envar("TAILSCALE_BINARY").Default("tailscale").StringVar(&cfg.Tailscale.Binary)
+	app.Flag("tailscale.socket", "Path of the tailscaled LocalAPI socket, like tailscaled --socket, or named pipe on Windows. Empty for the platform default.").Envar("TAILSCALE_SOCKET").Default("").StringVar(&cfg.Tailscale.Socket)
+	cfg.Tailscale.Instances = map[string]string{}
+	app.Flag("tailscale.instance", "Also monitor the tailscaled of this socket, name=socket (repeatable). Series are labeled with instance_name.").StringMapVar(&cfg.Tailscale.Instances)
+	app.Flag("tailscale.instance-name", "instance_name of the tailscaled at tailscale.socket when tailscale.instance is set.").Default("default").StringVar(&cfg.Tailscale.InstanceName)
+	app.Flag("tailscale.status-file", "Read the status from this JSON file, written by the record command or tailscale status -json, instead of tailscaled.").Default("").StringVar(&cfg.Tailscale.StatusFile)
+	app.Flag("tailscale.status-file-reload", "Re-read tailscale.status-file on every status fetch.").Default("false").BoolVar(&cfg.Tailscale.StatusFileReload)
+	app.Flag("tailscale.macos-gui", "Read status and LocalAPI collectors from the macOS App Store or standalone GUI client, over the localhost port and token of its sameuserproof file.").Default("false").BoolVar(&cfg.Tailscale.MacOSGUI)
+	app.Flag("tailscale.sameuserproof-dir", "Directory of the sameuserproof file of the macOS GUI client. Empty searches the App Store group container and /Library/Tailscale.").Default("").StringVar(&cfg.Tailscale.SameUserProofDir)
+	app.Flag("tailscale.timeout", "Timeout of tailscale status and LocalAPI calls.").Default("10s").DurationVar(&cfg.Tailscale.Timeout)
+	app.Flag("tailscale.retries", "How often to retry a failed tailscale status call, with exponential backoff.").Default("3").IntVar(&cfg.Tailscale.Retries)
+	app.Flag("tailscale.exec-concurrency", "Maximum number of tailscale CLI processes running at once, 0 is unlimited.").Default("2").IntVar(&cfg.Tailscale.ExecConcurrency)
+	app.Flag("tailscale.exec-min-interval", "Minimum interval between starts of the same tailscale CLI command, e.g. status, 0 is unlimited.").Default("0s").DurationVar(&cfg.Tailscale.ExecMinInterval)
+	app.Flag("collector.go", "Enable the Go runtime collector (go_* metrics).").Default("true").BoolVar(&cfg.Collectors.Go)
+	app.Flag("collector.process", "Enable the process collector (process_* metrics).").Default("true").BoolVar(&cfg.Collectors.Process)
+	app.Flag("collector.peers", "Enable the peer traffic collector.").Default("true").BoolVar(&cfg.Collectors.Peers)
+	app.Flag("collector.peers.route-info", "Export one info series per subnet route of this node and its peers.").Default("false").BoolVar(&cfg.Collectors.RouteInfo)
+	app.Flag("collector.peers.user-traffic", "Export user_rx_bytes_total and user_tx_bytes_total, the peer byte counters summed per owning user.").Default("false").BoolVar(&cfg.Collectors.UserTraffic)
+	app.Flag("collector.peers.tag-traffic", "Export tag_rx_bytes_total and tag_tx_bytes_total, the peer byte counters summed per peer tag.").Default("false").BoolVar(&cfg.Collectors.TagTraffic)
+	app.Flag("collector.peers.key-expiry-window", "Count peers whose node key expires within this duration in peers_key_expiring_soon.").Default("168h").DurationVar(&cfg.Collectors.KeyExpiryWindow)
+	app.Flag("collector.peers.grace-period", "Keep exporting peers gone from the netmap with their last values, offline and with peer_present 0, for this long. 0 drops their series at once.").Default("0s").DurationVar(&cfg.Collectors.PeerGracePeriod)
+	app.Flag("collector.peers.rate-interval", "Export peer_rx_bytes_rate and peer_tx_bytes_rate, bytes per second computed by the exporter from samples at least this far apart, for the JSON API and StatsD. 0 disables them.").Default("0s").DurationVar(&cfg.Collectors.PeerRateInterval)
+	app.Flag("collector.peers.accumulate", "Also export peer byte counters kept monotonic across tailscaled restarts and reconnections, with the detected resets.").Default("false").BoolVar(&cfg.Collectors.Accumulate)
+	app.Flag("collector.peers.accumulate-file", "Persist the accumulated peer byte counters to this file, so they survive exporter restarts. Additional instances append .<instance_name>.").Default("").StringVar(&cfg.Collectors.AccumulateFile)
+	app.Flag("collector.probes", "Enable the background ping probe collector.").Default("true").BoolVar(&cfg.Collectors.Probes)
+	app.Flag("collector.admin-api", "Enable the Admin API collector when credentials are set.").Default("true").BoolVar(&cfg.Collectors.AdminAPI)
+	app.Flag("collector.policy", "Enable the tailnet policy file version collector, read from the Admin API. Needs the policy_file:read scope.").Default("false").BoolVar(&cfg.Collectors.Policy)
+	app.Flag("collector.keys", "Enable the auth and API key expiry collector, read from the Admin API. Needs the auth_keys:read scope.").Default("false").BoolVar(&cfg.Collectors.Keys)
+	app.Flag("collector.keys.expiry-window", "Count keys expiring within this duration in keys_expiring_soon.").Default("168h").DurationVar(&cfg.Collectors.KeysExpiryWindow)
+	app.Flag("collector.tailnet-dns", "Enable the tailnet DNS settings collector, read from the Admin API. Needs the dns:read scope.").Default("false").BoolVar(&cfg.Collectors.TailnetDNS)
+	app.Flag("collector.scrape-deadline", "Export the partial results of a collector whose scrape takes longer, 0 waits for every collector. Set per collector with scrape_deadlines in the config file.").Default("0s").DurationVar(&cfg.Collectors.ScrapeDeadline)
+	app.Flag("collector.headscale", "Enable the Headscale collector when headscale.url is set.").Default("true").BoolVar(&cfg.Collectors.Headscale)
+	app.Flag("collector.webhook", "Enable the webhook collector and /webhook when webhook.secret is set.").Default("true").BoolVar(&cfg.Collectors.Webhook)
+	app.Flag("collector.prefs", "Enable the node preferences collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.Prefs)
+	app.Flag("collector.ssh", "Enable the Tailscale SSH collector, read from LocalAPI. Session counters need LocalAPI write access, e.g. running as root.").Default("false").BoolVar(&cfg.Collectors.SSH)
+	app.Flag("collector.serve", "Enable the serve and Funnel config collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.Serve)
+	app.Flag("collector.tailnet-lock", "Enable the tailnet lock status collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.TailnetLock)
+	app.Flag("collector.taildrop", "Enable the Taildrop inbox collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.Taildrop)
+	app.Flag("collector.taildrive", "Enable the Taildrive shares collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.Taildrive)
+	app.Flag("collector.dns", "Enable the tailnet DNS configuration collector, read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.DNS)
+	app.Flag("collector.cert", "Enable the HTTPS certificate expiry collector.").Default("false").BoolVar(&cfg.Collectors.Cert)
+	app.Flag("collector.cert.dir", "Directory of the certificates provisioned by tailscaled.").Default(defaultCertDir).StringVar(&cfg.Collectors.CertDir)
+	app.Flag("collector.usermetrics", "Re-expose the user metrics of tailscaled (tailscale metrics print), read from LocalAPI.").Default("false").BoolVar(&cfg.Collectors.UserMetrics)
+	app.Flag("collector.usermetrics.prefix", "Prefix of the re-exposed series. They keep the tailscaled_ names of tailscaled and are not prefixed by metrics.namespace.").Default("").StringVar(&cfg.Collectors.UserMetricsPrefix)
+	app.Flag("collector.netdev", "Enable the Tailscale interface packet, error and drop counters collector (Linux).").Default("false").BoolVar(&cfg.Collectors.NetDev)
+	app.Flag("collector.netdev.interface", "Name of the Tailscale TUN interface.").Default("tailscale0").StringVar(&cfg.Collectors.NetDevInterface)
+	app.Flag("collector.netdev.path", "Path of /proc/net/dev, e.g. /host/proc/net/dev in a container.").Default("/proc/net/dev").StringVar(&cfg.Collectors.NetDevPath)
+	app.Flag("collector.daemon", "Enable the tailscaled process CPU, memory and file descriptor collector, read from procfs (Linux).").Default("false").BoolVar(&cfg.Collectors.Daemon)
+	app.Flag("collector.forwarding", "Enable the IP forwarding and UDP GRO prerequisites collector of subnet routers and exit nodes, sysctls read from procfs (Linux).").Default("false").BoolVar(&cfg.Collectors.Forwarding)
+	app.Flag("collector.version", "Enable the tailscale CLI and tailscaled version mismatch collector, running tailscale version.").Default("false").BoolVar(&cfg.Collectors.Version)
+	app.Flag("collector.tailscaled-start", "Enable tailscaled_start_time_seconds, read from procfs where available.").Default("true").BoolVar(&cfg.Collectors.TailscaledStart)
+	app.Flag("collector.daemon.procfs", "procfs mount point, e.g. /host/proc in a container sharing the host PID namespace.").Default("/proc").StringVar(&cfg.Collectors.DaemonProcPath)
+	app.Flag("peer.include-tags", "Only export peers having at least one of these tags (repeatable).").StringsVar(&cfg.Peers.IncludeTags)
+	app.Flag("peer.exclude-tags", "Do not export peers having any of these tags (repeatable).").StringsVar(&cfg.Peers.ExcludeTags)
+	app.Flag("peer.include-regex", "Only export peers whose host name or DNS name fully matches this regex.").Default("").StringVar(&cfg.Peers.IncludeRegex)
+	app.Flag("peer.exclude-regex", "Do not export peers whose host name or DNS name fully matches this regex, e.g. ci-runner-.*.").Default("").StringVar(&cfg.Peers.ExcludeRegex)
+	app.Flag("peer.only-online", "Only export peers that are currently online.").BoolVar(&cfg.Peers.OnlyOnline)
+	app.Flag("peer.exclude-mullvad", "Do not export Mullvad exit node peers.").BoolVar(&cfg.Peers.ExcludeMullvad)
+	app.Flag("status.refresh-interval", "Refresh tailscale status in background on this interval and serve scrapes from cache. 0 fetches status on every scrape.").Default("0s").DurationVar(&cfg.Status.RefreshInterval)
+	app.Flag("status.max-age", "Stop exporting peer series when the cached status is older than this.").Default("2m").DurationVar(&cfg.Status.MaxAge)
+	app.Flag("status.watch-ipn-bus", "Keep the status in memory, updated from the LocalAPI IPN bus, instead of running tailscale status per scrape or refresh.").Default("false").BoolVar(&cfg.Status.WatchIPNBus)
+	app.Flag("status.resync-interval", "How often the IPN bus watcher fetches the full status, which refreshes peer traffic counters.").Default("1m").DurationVar(&cfg.Status.ResyncInterval)
+	app.Flag("collector.netcheck", "Enable the netcheck collector (DERP latency and NAT traversal).").Default("false").BoolVar(&cfg.Netcheck.Enabled)
+	app.Flag("netcheck.interval", "How often to run tailscale netcheck.").Default("5m").DurationVar(&cfg.Netcheck.Interval)
+	app.Flag("netcheck.timeout", "Timeout of a single tailscale netcheck run.").Default("1m").DurationVar(&cfg.Netcheck.Timeout)
+	app.Flag("probe.peers", "Peer (hostname or Tailscale IP) to ping in background (repeatable).").StringsVar(&cfg.Probe.Peers)
+	app.Flag("probe.tcp-target", "Peer service (peer:port) to connect to over the tailnet in background, e.g. db1:5432 (repeatable).").StringsVar(&cfg.Probe.TCPTargets)
+	app.Flag("probe.dns-name", "MagicDNS name to resolve in background, e.g. somehost.tailnet-foo.ts.net (repeatable).").StringsVar(&cfg.Probe.DNSNames)
+	app.Flag("probe.peerapi", "Peer (hostname or MagicDNS name) whose PeerAPI to request over HTTP in background, \"*\" for all online peers (repeatable).").StringsVar(&cfg.Probe.PeerAPI)
+	app.Flag("probe.dns-server", "Resolve probe.dns-name with this DNS server instead of the system resolver, e.g. 100.100.100.100:53.").Default("").StringVar(&cfg.Probe.DNSServer)
+	app.Flag("probe.interval", "How often to ping probe peers.").Default("30s").DurationVar(&cfg.Probe.Interval)
+	app.Flag("probe.timeout", "Timeout of a single ping or /probe request.").Default("5s").DurationVar(&cfg.Probe.Timeout)
+	app.Flag("web.config.file", "Path to configuration file that can enable TLS or authentication. See: https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md").Default("").StringVar(&cfg.Web.ConfigFile)
+	app.Flag("web.ready-max-age", "/readyz fails when the last successful status fetch is older than this and tailscaled does not answer.").Default("1m").DurationVar(&cfg.Web.ReadyMaxAge)
+	app.Flag("web.startup-timeout", "/readyz fails until the first successful status fetch, for at most this long after start. 0 disables the startup gate.").Default("0s").DurationVar(&cfg.Web.StartupTimeout)
+	app.Flag("web.startup-delay-serve", "Do not answer HTTP requests at all until the first successful status fetch or web.startup-timeout.").Default("false").BoolVar(&cfg.Web.StartupDelayServe)
+	app.Flag("web.rate-limit-requests", "Requests each client address may make to /metrics and /probe per web.rate-limit-interval, more are answered with 429. 0 for unlimited.").Default("0").IntVar(&cfg.Web.RateLimitRequests)
+	app.Flag("web.rate-limit-interval", "Window of web.rate-limit-requests.").Default("1m").DurationVar(&cfg.Web.RateLimitInterval)
+	app.Flag("web.shutdown-timeout", "How long to wait for in-flight requests on SIGTERM/SIGINT.").Default("30s").DurationVar(&cfg.Web.ShutdownTimeout)
+	app.Flag("web.listen-ipv4", "Listen on the node's Tailscale IPv4 address.").Default("true").BoolVar(&cfg.Web.ListenIPv4)
+	app.Flag("web.listen-ipv6", "Listen on the node's Tailscale IPv6 address.").Default("false").BoolVar(&cfg.Web.ListenIPv6)
+	app.Flag("web.listen-localhost", "Also listen on 127.0.0.1, e.g. for local debugging.").Default("false").BoolVar(&cfg.Web.ListenLocalhost)
+	app.Flag("web.listen-unix", "Also listen on this unix socket path, e.g. /run/tailscale-exporter.sock.").Default("").StringVar(&cfg.Web.ListenUnix)
+	app.Flag("web.enable-influx", "Serve the metrics as InfluxDB line protocol on /influx, e.g. for Telegraf.").Default("false").BoolVar(&cfg.Web.EnableInflux)
+	app.Flag("web.tls-cert-file", "Serve HTTPS with this certificate, as an alternative to web.config.file.").Default("").StringVar(&cfg.Web.TLSCertFile)
+	app.Flag("web.tls-key-file", "Private key of web.tls-cert-file.").Default("").StringVar(&cfg.Web.TLSKeyFile)
+	app.Flag("web.tls-client-ca-file", "Require client certificates signed by this CA (mTLS). Needs web.tls-cert-file.").Default("").StringVar(&cfg.Web.TLSClientCAFile)
+	app.Flag("web.enable-openmetrics", "Serve OpenMetrics with units and _created samples on /metrics to scrapers asking for it.").Default("true").BoolVar(&cfg.Web.EnableOpenMetrics)
+	app.Flag("web.enable-sd", "Serve Prometheus HTTP service discovery of the tailnet nodes on /sd.").Default("false").BoolVar(&cfg.Web.EnableSD)
+	app.Flag("web.enable-dashboard", "Serve a Grafana dashboard matching the exported metric names and labels on /dashboard.json.").Default("true").BoolVar(&cfg.Web.EnableDashboard)
+	app.Flag("web.enable-json-api", "Serve the metrics as JSON grouped into self, peers and aggregates on /api/v1/metrics.").Default("true").BoolVar(&cfg.Web.EnableJSONAPI)
+	app.Flag("web.enable-config-api", "Serve the peer filter and probe targets on /-/config: GET reads them as YAML, PUT replaces them without a restart. Needs auth.").Default("false").BoolVar(&cfg.Web.EnableConfigAPI)
+	app.Flag("web.config-api-persist", "Also write the changes of /-/config to the config file.").Default("false").BoolVar(&cfg.Web.ConfigAPIPersist)
+	app.Flag("web.sd-port", "Port of the discovered targets, overridden by /sd?port=.").Default("9100").StringVar(&cfg.Web.SDPort)
+	app.Flag("web.read-timeout", "Maximum duration for reading an entire request, headers included.").Default("10s").DurationVar(&cfg.Web.ReadTimeout)
+	app.Flag("web.write-timeout", "Maximum duration for writing a response, from the end of the request headers.").Default("1m").DurationVar(&cfg.Web.WriteTimeout)
+	app.Flag("web.idle-timeout", "How long keep-alive connections are kept idle.").Default("2m").DurationVar(&cfg.Web.IdleTimeout)
+	app.Flag("web.max-connections", "Maximum number of simultaneous connections. 0 for unlimited.").Default("0").IntVar(&cfg.Web.MaxConnections)
+	app.Flag("web.max-requests-in-flight", "Maximum number of concurrent scrapes of /metrics, more are answered with 503. 0 for unlimited.").Default("0").IntVar(&cfg.Web.MaxRequestsInFlight)
+	app.Flag("access.allow-tags", "Only allow HTTP requests from tailnet nodes with this tag, resolved via WhoIs (repeatable).").StringsVar(&cfg.Access.AllowTags)
+	app.Flag("access.allow-users", "Only allow HTTP requests from untagged nodes of this login name, resolved via WhoIs (repeatable).").StringsVar(&cfg.Access.AllowUsers)
+	app.Flag("access.allow-capabilities", "Only allow HTTP requests from peers granted this capability, resolved via WhoIs (repeatable).").StringsVar(&cfg.Access.AllowCapabilities)
+	cfg.Auth.BasicAuthUsers = map[string]string{}
+	app.Flag("auth.basic-auth-user", "Require HTTP basic auth on /metrics and /probe, user=bcrypt hash of the password (repeatable).").StringMapVar(&cfg.Auth.BasicAuthUsers)
+	app.Flag("auth.bearer-token", "Accept this bearer token on /metrics and /probe (repeatable).").Envar("AUTH_BEARER_TOKEN").StringsVar(&cfg.Auth.BearerTokens)
+	app.Flag("tsnet", "Join the tailnet as its own ephemeral node instead of using the local tailscaled. Auth key is read from TS_AUTHKEY.").Default("false").BoolVar(&cfg.Tsnet.Enabled)
+	app.Flag("tsnet.hostname", "Hostname of the tsnet node.").Default("tailscale-exporter").StringVar(&cfg.Tsnet.Hostname)
+	app.Flag("tsnet.state-dir", "Directory for tsnet state. Defaults to a directory under the user config dir.").Default("").StringVar(&cfg.Tsnet.StateDir)
+	app.Flag("tsnet.up-timeout", "How long to wait for the tsnet node to come up.").Default("5m").DurationVar(&cfg.Tsnet.UpTimeout)
+	app.Flag("federate", "Scrape the exporters of online peers over the tailnet and serve their series merged on /federate, labeled with the peer.").Default("false").BoolVar(&cfg.Federate.Enabled)
+	app.Flag("federate.tag", "Only federate peers having at least one of these tags (repeatable).").StringsVar(&cfg.Federate.Tags)
+	app.Flag("federate.port", "Port of the peer exporters.").Default("9100").StringVar(&cfg.Federate.Port)
+	app.Flag("federate.path", "Metrics path of the peer exporters.").Default("/metrics").StringVar(&cfg.Federate.Path)
+	app.Flag("federate.timeout", "Timeout of a /federate scrape of all peers.").Default("10s").DurationVar(&cfg.Federate.Timeout)
+	app.Flag("serve", "Expose /metrics over HTTPS on the node's MagicDNS name via tailscale serve.").Default("false").BoolVar(&cfg.Serve.Enabled)
+	app.Flag("serve.port", "HTTPS port used by tailscale serve.").Default("443").Uint16Var(&cfg.Serve.Port)
+	app.Flag("serve.funnel", "Also expose the served /metrics to the internet via Funnel.").Default("false").BoolVar(&cfg.Serve.Funnel)
+	app.Flag("admin-api.base-url", "Base URL of the Tailscale Admin API.").Default("https://api.tailscale.com").StringVar(&cfg.AdminAPI.BaseURL)
+	app.Flag("admin-api.tailnet", "Tailnet name for Admin API calls. \"-\" is the default tailnet of the credentials.").Default("-").StringVar(&cfg.AdminAPI.Tailnet)
+	app.Flag("admin-api.key", "Admin API access token. Enables the Admin API collector.").Envar("TS_API_KEY").Default("").StringVar(&cfg.AdminAPI.Key)
+	app.Flag("admin-api.oauth-client-id", "Admin API OAuth client ID. Enables the Admin API collector.").Envar("TS_API_CLIENT_ID").Default("").StringVar(&cfg.AdminAPI.OAuthClientID)
+	app.Flag("admin-api.oauth-client-secret", "Admin API OAuth client secret.").Envar("TS_API_CLIENT_SECRET").Default("").StringVar(&cfg.AdminAPI.OAuthClientSecret)
+	app.Flag("admin-api.timeout", "Timeout of an Admin API collection.").Default("10s").DurationVar(&cfg.AdminAPI.Timeout)
+	app.Flag("admin-api.posture-attribute", "Export this device posture attribute, e.g. custom:diskEncryption (repeatable). Fetched per device, needs the devices:posture_attributes:read scope.").StringsVar(&cfg.AdminAPI.PostureAttributes)
+	app.Flag("headscale.url", "Base URL of a Headscale server. Enables the Headscale collector.").Default("").StringVar(&cfg.Headscale.URL)
+	app.Flag("headscale.api-key", "Headscale API key.").Envar("HEADSCALE_API_KEY").Default("").StringVar(&cfg.Headscale.APIKey)
+	app.Flag("headscale.timeout", "Timeout of a Headscale API collection.").Default("10s").DurationVar(&cfg.Headscale.Timeout)
+	app.Flag("webhook.secret", "Tailscale webhook signing secret. Enables the /webhook endpoint.").Envar("TS_WEBHOOK_SECRET").Default("").StringVar(&cfg.Webhook.Secret)
+	app.Flag("webhook.listen-address", "Address of the /webhook listener. Tailscale delivers from the internet, so expose it with a port forward, a reverse proxy or tailscale funnel.").Default(":9996").StringVar(&cfg.Webhook.ListenAddress)
+	app.Flag("debug.enable-pprof", "Serve net/http/pprof and expvar on the debug listener.").Default("false").BoolVar(&cfg.Debug.EnablePprof)
+	app.Flag("debug.listen-address", "Address of the debug listener.").Default("127.0.0.1:6060").StringVar(&cfg.Debug.ListenAddress)
+	app.Flag("debug.enable-status", "Serve the last fetched tailscale status JSON on /debug/status of the metrics listener.").Default("false").BoolVar(&cfg.Debug.EnableStatus)
+	app.Flag("debug.status-redact", "Replace host names, DNS names, IPs, keys and user names in /debug/status. Always on with labels.redact or labels.hash.").Default("false").BoolVar(&cfg.Debug.StatusRedact)
+	app.Flag("output.textfile-dir", "Periodically write metrics to tailscale-exporter.prom in this directory, for the node_exporter textfile collector.").Default("").StringVar(&cfg.Output.TextfileDir)
+	app.Flag("output.textfile-interval", "How often to write the textfile.").Default("1m").DurationVar(&cfg.Output.TextfileInterval)
+	app.Flag("output.textfile-only", "Only write the textfile, do not serve HTTP.").Default("false").BoolVar(&cfg.Output.TextfileOnly)
+	app.Flag("output.file-sd-path", "Periodically write the tailnet nodes to this Prometheus file_sd file, YAML if it ends in .yml or .yaml, JSON otherwise.").Default("").StringVar(&cfg.Output.FileSDPath)
+	app.Flag("output.file-sd-interval", "How often to write the file_sd file.").Default("1m").DurationVar(&cfg.Output.FileSDInterval)
+	app.Flag("output.file-sd-port", "Port of the targets in the file_sd file.").Default("9100").StringVar(&cfg.Output.FileSDPort)
+	app.Flag("output.file-sd-tag", "Only write nodes having at least one of these tags, e.g. tag:prometheus-target (repeatable).").StringsVar(&cfg.Output.FileSDTags)
+	app.Flag("otlp.endpoint", "Push metrics over OTLP to this collector URL, e.g. http://otel-collector:4317.").Default("").StringVar(&cfg.OTLP.Endpoint)
+	app.Flag("otlp.protocol", "OTLP protocol: grpc or http/protobuf.").Default("grpc").EnumVar(&cfg.OTLP.Protocol, "grpc", "http/protobuf")
+	app.Flag("otlp.interval", "How often to push metrics over OTLP.").Default("1m").DurationVar(&cfg.OTLP.Interval)
+	cfg.OTLP.Headers = map[string]string{}
+	cfg.RemoteWrite.Labels = map[string]string{}
+	cfg.Push.Grouping = map[string]string{}
+	cfg.StatsD.Tags = map[string]string{}
+	app.Flag("otlp.header", "Header sent with OTLP pushes, e.g. Authorization=Bearer xyz (repeatable).").StringMapVar(&cfg.OTLP.Headers)
+	app.Flag("remote-write.url", "Push metrics with Prometheus remote_write to this URL, e.g. Mimir or VictoriaMetrics.").Default("").StringVar(&cfg.RemoteWrite.URL)
+	app.Flag("remote-write.interval", "How often to push metrics with remote_write.").Default("1m").DurationVar(&cfg.RemoteWrite.Interval)
+	app.Flag("remote-write.username", "Basic auth username for remote_write.").Default("").StringVar(&cfg.RemoteWrite.Username)
+	app.Flag("remote-write.password", "Basic auth password for remote_write.").Envar("REMOTE_WRITE_PASSWORD").Default("").StringVar(&cfg.RemoteWrite.Password)
+	app.Flag("remote-write.bearer-token", "Bearer token for remote_write.").Envar("REMOTE_WRITE_BEARER_TOKEN").Default("").StringVar(&cfg.RemoteWrite.BearerToken)
+	app.Flag("remote-write.label", "Label added to every pushed series, e.g. cluster=prod (repeatable). job and instance default to tailscale-exporter and the hostname.").StringMapVar(&cfg.RemoteWrite.Labels)
+	app.Flag("push.gateway-url", "Push metrics to this Prometheus Pushgateway, e.g. http://pushgateway:9091.").Default("").StringVar(&cfg.Push.URL)
+	app.Flag("push.interval", "How often to push metrics to the Pushgateway.").Default("1m").DurationVar(&cfg.Push.Interval)
+	app.Flag("push.job", "job label of the pushed group.").Default("tailscale-exporter").StringVar(&cfg.Push.Job)
+	app.Flag("push.instance", "instance label of the pushed group, defaults to the hostname.").Default("").StringVar(&cfg.Push.Instance)
+	app.Flag("push.username", "Basic auth username for the Pushgateway.").Default("").StringVar(&cfg.Push.Username)
+	app.Flag("push.password", "Basic auth password for the Pushgateway.").Envar("PUSHGATEWAY_PASSWORD").Default("").StringVar(&cfg.Push.Password)
+	app.Flag("push.grouping", "Additional grouping label of the pushed group, e.g. cluster=prod (repeatable).").StringMapVar(&cfg.Push.Grouping)
+	app.Flag("statsd.address", "Send counters and gauges to this StatsD or DogStatsD server over UDP, e.g. localhost:8125.").Default("").StringVar(&cfg.StatsD.Address)
+	app.Flag("statsd.interval", "How often to send metrics to StatsD.").Default("10s").DurationVar(&cfg.StatsD.Interval)
+	app.Flag("statsd.format", "StatsD format: dogstatsd sends labels as tags, statsd appends label values to the metric name.").Default("dogstatsd").EnumVar(&cfg.StatsD.Format, "dogstatsd", "statsd")
+	app.Flag("statsd.tag", "Tag added to every metric sent to DogStatsD, e.g. env=prod (repeatable).").StringMapVar(&cfg.StatsD.Tags)
+	cfg.SSH.Hosts = map[string]string{}
+	app.Flag("ssh.host", "Collect the tailscaled of this host over SSH, name=address (repeatable). Series are labeled with remote_host.").StringMapVar(&cfg.SSH.Hosts)
+	app.Flag("ssh.user", "User of the SSH connections.").Default("root").StringVar(&cfg.SSH.User)
+	app.Flag("ssh.key-file", "Private key of the SSH connections.").Default("").StringVar(&cfg.SSH.KeyFile)
+	app.Flag("ssh.known-hosts-file", "known_hosts file verifying the SSH host keys.").Default("").StringVar(&cfg.SSH.KnownHostsFile)
+	app.Flag("ssh.command", "Command printing the status JSON on the remote hosts.").Default("tailscale status --json").StringVar(&cfg.SSH.Command)
+	app.Flag("ssh.timeout", "Timeout of a remote status, connecting included.").Default("10s").DurationVar(&cfg.SSH.Timeout)
+}
+
+// LoadConfig applies the YAML file at path on top of base. Unknown keys are an error.
+func LoadConfig(path string, base Config) (Config, error) {
+	cfg := base
+	if path == "" {
+		return cfg, cfg.Validate()
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error on read config: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("error on parse config %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+var namespaceRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Validate reports all invalid and conflicting settings at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.Metrics.Namespace != "" && !namespaceRe.MatchString(cfg.Metrics.Namespace) {
+		errs = append(errs, fmt.Errorf("invalid metrics namespace %q", cfg.Metrics.Namespace))
+	}
+	if cfg.Tsnet.Enabled && (cfg.Netcheck.Enabled || len(cfg.Probe.Peers) > 0 || len(cfg.Probe.TCPTargets) > 0 || len(cfg.Probe.DNSNames) > 0 || len(cfg.Probe.PeerAPI) > 0 || cfg.Serve.Enabled) {
+		errs = append(errs, fmt.Errorf("netcheck collector, ping, tcp, dns and peerapi probes and serve use the local tailscaled and are not supported in tsnet mode"))
+	}
+	if (cfg.Web.TLSCertFile == "") != (cfg.Web.TLSKeyFile == "") || (cfg.Web.TLSClientCAFile != "" && cfg.Web.TLSCertFile == "") {
+		errs = append(errs, fmt.Errorf("web.tls-cert-file and web.tls-key-file must be set together, web.tls-client-ca-file needs both"))
+	}
+	if cfg.Web.TLSCertFile != "" && cfg.Web.ConfigFile != "" {
+		errs = append(errs, fmt.Errorf("web.tls-cert-file and web.config.file are mutually exclusive"))
+	}
+	if _, ok := cfg.Tailscale.Instances[cfg.Tailscale.InstanceName]; ok || (len(cfg.Tailscale.Instances) > 0 && cfg.Tailscale.InstanceName == "") {
+		errs = append(errs, fmt.Errorf("tailscale.instance-name %q must be set and differ from the tailscale.instance names", cfg.Tailscale.InstanceName))
+	}
+	if len(cfg.Tailscale.Instances) > 0 && cfg.Tsnet.Enabled {
+		errs = append(errs, fmt.Errorf("tailscale.instance is not supported in tsnet mode"))
+	}
+	if cfg.Tailscale.StatusFile != "" && (cfg.Tsnet.Enabled || cfg.Status.WatchIPNBus || len(cfg.Tailscale.Instances) > 0) {
+		errs = append(errs, fmt.Errorf("tailscale.status-file is not supported with tsnet, status.watch-ipn-bus or tailscale.instance"))
+	}
+	if cfg.Tailscale.MacOSGUI && (cfg.Tailscale.Socket != "" || cfg.Tsnet.Enabled || len(cfg.Tailscale.Instances) > 0) {
+		errs = append(errs, fmt.Errorf("tailscale.macos-gui is not supported with tailscale.socket, tsnet or tailscale.instance"))
+	}
+	if cfg.Tailscale.Retries < 0 || cfg.Tailscale.Retries > 10 {
+		errs = append(errs, fmt.Errorf("tailscale.retries must be between 0 and 10"))
+	}
+	if _, err := collector.NewRelabelGatherer(nil, cfg.Relabel); err != nil {
+		errs = append(errs, err)
+	}
+	peers := cfg.Peers
+	if err := peers.Compile(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Metrics.AggregateOnly && cfg.Collectors.Accumulate {
+		errs = append(errs, fmt.Errorf("collector.peers.accumulate adjusts the series per peer and does not work with metrics.aggregate-only"))
+	}
+	if cfg.Push.URL != "" && (cfg.Push.Job == "" || cfg.Push.Interval <= 0) {
+		errs = append(errs, fmt.Errorf("push.gateway-url needs a push.job and a positive push.interval"))
+	}
+	if cfg.StatsD.Address != "" && cfg.StatsD.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("statsd.address needs a positive statsd.interval"))
+	}
+	if cfg.StatsD.Format != "dogstatsd" && cfg.StatsD.Format != "statsd" {
+		errs = append(errs, fmt.Errorf("unknown statsd.format %q", cfg.StatsD.Format))
+	}
+	if cfg.Web.StartupDelayServe && cfg.Web.StartupTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("web.startup-delay-serve needs a positive web.startup-timeout"))
+	}
+	if len(cfg.SSH.Hosts) > 0 && (cfg.SSH.KeyFile == "" || cfg.SSH.KnownHostsFile == "") {
+		errs = append(errs, fmt.Errorf("ssh.host needs ssh.key-file and ssh.known-hosts-file"))
+	}
+	if cfg.Probe.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("probe.interval must be positive"))
+	}
+	if cfg.Web.RateLimitRequests > 0 && cfg.Web.RateLimitInterval <= 0 {
+		errs = append(errs, fmt.Errorf("web.rate-limit-requests needs a positive web.rate-limit-interval"))
+	}
+	if cfg.Web.EnableConfigAPI && !cfg.Auth.Enabled() {
+		errs = append(errs, fmt.Errorf("web.enable-config-api needs auth.basic-auth-user or auth.bearer-token"))
+	}
+	if cfg.Labels.Redact && cfg.Labels.Hash {
+		errs = append(errs, fmt.Errorf("labels.redact and labels.hash are mutually exclusive"))
+	}
+	if cfg.Labels.Hash && cfg.Labels.HashSalt == "" {
+		errs = append(errs, fmt.Errorf("labels.hash needs labels.hash-salt, unsalted hashes of IPs can be reversed"))
+	}
+	if cfg.Status.WatchIPNBus && cfg.Status.RefreshInterval > 0 {
+		errs = append(errs, fmt.Errorf("status.watch-ipn-bus and status.refresh-interval are mutually exclusive"))
+	}
+	if cfg.Output.TextfileOnly && (cfg.Output.TextfileDir == "" || cfg.Serve.Enabled) {
+		errs = append(errs, fmt.Errorf("output.textfile-only needs output.textfile-dir and does not work with serve"))
+	}
+	if !cfg.Tsnet.Enabled && !cfg.Output.TextfileOnly && !cfg.Web.ListenIPv4 && !cfg.Web.ListenIPv6 && !cfg.Web.ListenLocalhost && cfg.Web.ListenUnix == "" {
+		errs = append(errs, fmt.Errorf("at least one of web.listen-ipv4, web.listen-ipv6, web.listen-localhost and web.listen-unix must be enabled"))
+	}
+	if cfg.Collectors.Webhook && cfg.Webhook.Secret != "" {
+		if _, port, err := net.SplitHostPort(cfg.Webhook.ListenAddress); err != nil {
+			errs = append(errs, fmt.Errorf("invalid webhook.listen-address %q: %w", cfg.Webhook.ListenAddress, err))
+		} else if port == "9995" {
+			errs = append(errs, fmt.Errorf("webhook.listen-address %q conflicts with the metrics listener on port 9995", cfg.Webhook.ListenAddress))
+		}
+	}
+	if cfg.Debug.EnablePprof {
+		host, port, err := net.SplitHostPort(cfg.Debug.ListenAddress)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid debug.listen-address %q: %w", cfg.Debug.ListenAddress, err))
+		} else if port == "9995" && cfg.Web.ListenLocalhost && (host == "" || host == "127.0.0.1" || host == "localhost") {
+			errs = append(errs, fmt.Errorf("debug.listen-address %q conflicts with web.listen-localhost on 127.0.0.1:9995", cfg.Debug.ListenAddress))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restartOnly drops settings that are applied live, leaving the ones that need a restart.
+func (cfg Config) restartOnly() Config {
+	cfg.Peers = collector.PeerFilter{}
+	cfg.Probe.Peers = nil
+	cfg.Probe.TCPTargets = nil
+	cfg.Probe.DNSNames = nil
+	cfg.Probe.PeerAPI = nil
+	cfg.Probe.Interval = 0
+	cfg.Access = server.AccessPolicy{}
+	return cfg
+}
+
+// Reloader re-reads the config file on SIGHUP or POST /-/reload and hands it to Apply.
+// Only peer filters, probe targets/interval and access policy are applied live.
+type Reloader struct {
+	Path  string
+	Base  Config
+	Apply func(cfg Config)
+
+	mu      sync.Mutex
+	current Config
+}
+
+func NewReloader(path string, base Config, current Config, apply func(cfg Config)) *Reloader {
+	return &Reloader{Path: path, Base: base, Apply: apply, current: current}
+}
+
+func (reloader *Reloader) Reload() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	cfg, err := LoadConfig(reloader.Path, reloader.Base)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(cfg.restartOnly(), reloader.current.restartOnly()) {
+		slog.Warn("config reload: some changed settings only take effect after restart")
+	}
+	reloader.Apply(cfg)
+	reloader.current = cfg
+	slog.Info("config reloaded", "path", reloader.Path)
+	return nil
+}
+
+func (reloader *Reloader) WatchSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloader.Reload(); err != nil {
+			slog.Error("config reload failed", "err", err)
+		}
+	}
+}
+
+func (reloader *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloader.Reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
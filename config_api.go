@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"tailscale-exporter/collector"
+)
+
+// liveConfig is the part of the config /-/config reads and replaces, in the layout of the config file.
+type liveConfig struct {
+	Peers collector.PeerFilter `yaml:"peers"`
+	Probe struct {
+		Peers      []string `yaml:"peers"`
+		TCPTargets []string `yaml:"tcp_targets"`
+		DNSNames   []string `yaml:"dns_names"`
+		PeerAPI    []string `yaml:"peerapi"`
+	} `yaml:"probe"`
+}
+
+// ConfigAPI serves the peer filter and probe targets as YAML on GET /-/config and replaces them on PUT,
+// so they can be changed without a restart. With Persist, they are also written to the config file at Path,
+// otherwise the next config reload brings back those of the file.
+type ConfigAPI struct {
+	Apply   func(cfg Config)
+	Path    string
+	Persist bool
+
+	mu      sync.Mutex
+	current Config
+}
+
+func NewConfigAPI(path string, persist bool, current Config, apply func(cfg Config)) *ConfigAPI {
+	return &ConfigAPI{Apply: apply, Path: path, Persist: persist, current: current}
+}
+
+// SetCurrent records a config applied by someone else, e.g. a reload of the config file.
+func (api *ConfigAPI) SetCurrent(cfg Config) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.current = cfg
+}
+
+func (api *ConfigAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		live := liveConfig{Peers: api.current.Peers}
+		live.Probe.Peers = api.current.Probe.Peers
+		live.Probe.TCPTargets = api.current.Probe.TCPTargets
+		live.Probe.DNSNames = api.current.Probe.DNSNames
+		live.Probe.PeerAPI = api.current.Probe.PeerAPI
+		w.Header().Set("Content-Type", "application/yaml")
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&live); err != nil {
+			slog.Error("error on write live config", "err", err)
+		}
+	case http.MethodPut:
+		live := liveConfig{}
+		decoder := yaml.NewDecoder(io.LimitReader(r.Body, 1<<20))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&live); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("invalid config: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := live.Peers.Compile(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %s", err), http.StatusBadRequest)
+			return
+		}
+		cfg := api.current
+		cfg.Peers = live.Peers
+		cfg.Probe.Peers = live.Probe.Peers
+		cfg.Probe.TCPTargets = live.Probe.TCPTargets
+		cfg.Probe.DNSNames = live.Probe.DNSNames
+		cfg.Probe.PeerAPI = live.Probe.PeerAPI
+		if err := cfg.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %s", err), http.StatusBadRequest)
+			return
+		}
+		if api.Persist {
+			if err := persistLiveConfig(api.Path, live); err != nil {
+				http.Error(w, fmt.Sprintf("failed to persist config: %s", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		api.Apply(cfg)
+		api.current = cfg
+		slog.Info("live config replaced", "persisted", api.Persist)
+		w.Write([]byte("ok\n"))
+	default:
+		http.Error(w, "Only GET or PUT requests allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// persistLiveConfig replaces the peers and probe target keys of the config file at path, keeping the others.
+func persistLiveConfig(path string, live liveConfig) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error on read config file: %w", err)
+	}
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(content, document); err != nil {
+		return fmt.Errorf("error on parse config file: %w", err)
+	}
+	if document.Kind == 0 {
+		document = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := document.Content[0]
+	peers := &yaml.Node{}
+	if err := peers.Encode(live.Peers); err != nil {
+		return fmt.Errorf("error on encode peers: %w", err)
+	}
+	setKey(root, "peers", peers)
+	probe := mappingKey(root, "probe")
+	targets := &yaml.Node{}
+	if err := targets.Encode(live.Probe); err != nil {
+		return fmt.Errorf("error on encode probe targets: %w", err)
+	}
+	for i := 0; i+1 < len(targets.Content); i += 2 {
+		setKey(probe, targets.Content[i].Value, targets.Content[i+1])
+	}
+	out := bytes.NewBuffer(nil)
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("error on encode config file: %w", err)
+	}
+	// write next to it and rename, a crash must not leave a truncated config
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error on write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error on write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error on write config file: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error on replace config file: %w", err)
+	}
+	return nil
+}
+
+// setKey sets key of a mapping node to value, keeping the comments of an existing key.
+func setKey(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// mappingKey returns the mapping node of key, added if missing.
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key && mapping.Content[i+1].Kind == yaml.MappingNode {
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(mapping, key, value)
+	return value
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/alecthomas/kingpin/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// defaultConfig returns the config of the flag defaults.
+func defaultConfig(t *testing.T) Config {
+	t.Helper()
+	cfg := Config{}
+	app := kingpin.New("test", "")
+	RegisterFlags(app, &cfg)
+	if _, err := app.Parse(nil); err != nil {
+		t.Fatalf("error on parse flags: %v", err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	cfg := defaultConfig(t)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   string
+	}{
+		{"zero probe interval", func(cfg *Config) { cfg.Probe.Interval = 0 }, "probe.interval must be positive"},
+		{"negative probe interval", func(cfg *Config) { cfg.Probe.Interval = -time.Second }, "probe.interval must be positive"},
+		{"too many retries", func(cfg *Config) { cfg.Tailscale.Retries = 11 }, "tailscale.retries"},
+		{"hash without salt", func(cfg *Config) { cfg.Labels.Hash = true }, "labels.hash needs labels.hash-salt"},
+		{"redact and hash", func(cfg *Config) { cfg.Labels.Redact, cfg.Labels.Hash, cfg.Labels.HashSalt = true, true, "s" }, "mutually exclusive"},
+		{"rate limit without interval", func(cfg *Config) { cfg.Web.RateLimitRequests, cfg.Web.RateLimitInterval = 10, 0 }, "web.rate-limit"},
+		{"webhook on the metrics port", func(cfg *Config) { cfg.Webhook.Secret, cfg.Webhook.ListenAddress = "s", ":9995" }, "webhook.listen-address"},
+		{"invalid namespace", func(cfg *Config) { cfg.Metrics.Namespace = "tail-scale" }, "invalid metrics namespace"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultConfig(t)
+			test.change(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	base := defaultConfig(t)
+	var applied []Config
+	reloader := NewReloader(path, base, base, func(cfg Config) { applied = append(applied, cfg) })
+	reload := func(content string) int {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		reloader.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+		return recorder.Code
+	}
+
+	if code := reload("probe:\n  interval: 1m\n  peers: [db1]\n"); code != http.StatusOK {
+		t.Fatalf("valid config: got status %d, want 200", code)
+	}
+	if len(applied) != 1 || applied[0].Probe.Interval != time.Minute || applied[0].Probe.Peers[0] != "db1" {
+		t.Fatalf("valid config: applied %+v", applied)
+	}
+	for _, content := range []string{"probe:\n  interval: 0s\n", "probe:\n  unknown: 1\n", "probe: ["} {
+		if code := reload(content); code != http.StatusInternalServerError {
+			t.Errorf("%q: got status %d, want 500", content, code)
+		}
+	}
+	if len(applied) != 1 {
+		t.Errorf("invalid configs were applied: %d", len(applied)-1)
+	}
+	recorder := httptest.NewRecorder()
+	reloader.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want 405", recorder.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alecthomas/kingpin/v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// generateConfig writes the compiled-in defaults as a config file, each key commented with the help of its flag.
+func generateConfig(out io.Writer) error {
+	app := kingpin.New("tailscale-exporter", "")
+	cfg := Config{}
+	RegisterFlags(app, &cfg)
+	for _, flag := range app.Model().Flags {
+		// secrets in the environment must not end up in the generated file
+		app.GetFlag(flag.Name).NoEnvar()
+	}
+	if _, err := app.Parse(nil); err != nil {
+		return fmt.Errorf("error on parse defaults: %w", err)
+	}
+	helps := flagHelps(app, reflect.ValueOf(&cfg).Elem())
+	document := &yaml.Node{}
+	if err := document.Encode(&cfg); err != nil {
+		return fmt.Errorf("error on encode config: %w", err)
+	}
+	commentFields(document, reflect.ValueOf(&cfg).Elem(), helps)
+	document.HeadComment = fmt.Sprintf("Default configuration of tailscale-exporter %s, load it with --config.file.\nKeys override the command line flags named in their comments.", version)
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("error on write config: %w", err)
+	}
+	return encoder.Close()
+}
+
+// flagHelps maps the addresses of the fields of cfg to the comment of the flag setting them.
+func flagHelps(app *kingpin.Application, cfg reflect.Value) map[uintptr]string {
+	fields := map[uintptr]bool{}
+	walkFields(cfg, func(field reflect.Value) { fields[field.Addr().Pointer()] = true })
+	helps := map[uintptr]string{}
+	for _, flag := range app.Model().Flags {
+		comment := fmt.Sprintf("--%s: %s", flag.Name, flag.Help)
+		if target := flagTarget(flag.Value); fields[target] {
+			helps[target] = comment
+			continue
+		}
+		if repeatable, ok := flag.Value.(interface{ IsCumulative() bool }); !ok || !repeatable.IsCumulative() {
+			continue
+		}
+		// repeatable flags keep their target slice out of reach, setting them on a scratch config reveals it
+		scratch := Config{}
+		scratchApp := kingpin.New("tailscale-exporter", "")
+		RegisterFlags(scratchApp, &scratch)
+		if _, err := scratchApp.Parse([]string{"--" + flag.Name + "=x=x"}); err != nil {
+			continue
+		}
+		scratchValue := reflect.ValueOf(&scratch).Elem()
+		walkFields(scratchValue, func(field reflect.Value) {
+			if field.Kind() == reflect.Slice && field.Len() > 0 {
+				offset := field.Addr().Pointer() - scratchValue.Addr().Pointer()
+				helps[cfg.Addr().Pointer()+offset] = comment
+			}
+		})
+	}
+	return helps
+}
+
+// flagTarget returns the address of the variable a kingpin value sets, 0 if it is not known.
+func flagTarget(value kingpin.Value) uintptr {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Pointer {
+		return 0
+	}
+	// values are either the target converted to a named type or a struct pointing to it
+	if elem := rv.Elem(); elem.Kind() == reflect.Struct {
+		if elem.NumField() > 0 && elem.Field(0).Kind() == reflect.Pointer {
+			return elem.Field(0).Pointer()
+		}
+		return 0
+	}
+	return rv.Pointer()
+}
+
+// walkFields calls fn with every field of the struct value, nested structs included.
+func walkFields(value reflect.Value, fn func(field reflect.Value)) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() == reflect.Struct {
+			walkFields(field, fn)
+			continue
+		}
+		fn(field)
+	}
+}
+
+// commentFields sets the head comment of every key of the encoded node from the field of value it was encoded from.
+func commentFields(node *yaml.Node, value reflect.Value, helps map[uintptr]string) {
+	if node.Kind == yaml.DocumentNode {
+		commentFields(node.Content[0], value, helps)
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, child := node.Content[i], node.Content[i+1]
+		field, ok := fieldByYAMLName(value, key.Value)
+		if !ok {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			commentFields(child, field, helps)
+			continue
+		}
+		if help, ok := helps[field.Addr().Pointer()]; ok {
+			key.HeadComment = help
+		} else {
+			key.HeadComment = "Config file only."
+		}
+	}
+}
+
+func fieldByYAMLName(value reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < value.NumField(); i++ {
+		tag, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == name {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -1,197 +1,719 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"log"
+	"github.com/prometheus/common/promslog"
+	promslogflag "github.com/prometheus/common/promslog/flag"
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/net/netutil"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
-	"os/exec"
-	"slices"
-	"strconv"
+	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"syscall"
+	"tailscale-exporter/collector"
+	"tailscale-exporter/server"
+	"tailscale-exporter/tailscaleclient"
+	"tailscale.com/client/local"
 	"time"
 )
 
-type TailscaleStatus struct {
-	Version      string   `json:"Version"`
-	TUN          bool     `json:"TUN"`
-	BackendState string   `json:"BackendState"`
-	AuthURL      string   `json:"AuthURL"`
-	TailscaleIPs []string `json:"TailscaleIPs"`
-	Self         struct {
-		ID             string                 `json:"ID"`
-		PublicKey      string                 `json:"PublicKey"`
-		HostName       string                 `json:"HostName"`
-		DNSName        string                 `json:"DNSName"`
-		OS             string                 `json:"OS"`
-		UserID         int                    `json:"UserID"`
-		TailscaleIPs   []string               `json:"TailscaleIPs"`
-		AllowedIPs     []string               `json:"AllowedIPs"`
-		Tags           []string               `json:"Tags"`
-		Addrs          []string               `json:"Addrs"`
-		CurAddr        string                 `json:"CurAddr"`
-		Relay          string                 `json:"Relay"`
-		RxBytes        int                    `json:"RxBytes"`
-		TxBytes        int                    `json:"TxBytes"`
-		Created        time.Time              `json:"Created"`
-		LastWrite      time.Time              `json:"LastWrite"`
-		LastSeen       time.Time              `json:"LastSeen"`
-		LastHandshake  time.Time              `json:"LastHandshake"`
-		Online         bool                   `json:"Online"`
-		ExitNode       bool                   `json:"ExitNode"`
-		ExitNodeOption bool                   `json:"ExitNodeOption"`
-		Active         bool                   `json:"Active"`
-		PeerAPIURL     []string               `json:"PeerAPIURL"`
-		Capabilities   []string               `json:"Capabilities"`
-		CapMap         map[string]interface{} `json:"CapMap"`
-		InNetworkMap   bool                   `json:"InNetworkMap"`
-		InMagicSock    bool                   `json:"InMagicSock"`
-		InEngine       bool                   `json:"InEngine"`
-	} `json:"Self"`
-	MagicDNSSuffix string `json:"MagicDNSSuffix"`
-	CurrentTailnet struct {
-		Name            string `json:"Name"`
-		MagicDNSSuffix  string `json:"MagicDNSSuffix"`
-		MagicDNSEnabled bool   `json:"MagicDNSEnabled"`
-	} `json:"CurrentTailnet"`
-	Peer map[string]struct {
-		ID             string    `json:"ID"`
-		PublicKey      string    `json:"PublicKey"`
-		HostName       string    `json:"HostName"`
-		DNSName        string    `json:"DNSName"`
-		OS             string    `json:"OS"`
-		UserID         int       `json:"UserID"`
-		TailscaleIPs   []string  `json:"TailscaleIPs"`
-		AllowedIPs     []string  `json:"AllowedIPs"`
-		Tags           []string  `json:"Tags"`
-		CurAddr        string    `json:"CurAddr"`
-		Relay          string    `json:"Relay"`
-		RxBytes        int       `json:"RxBytes"`
-		TxBytes        int       `json:"TxBytes"`
-		Created        time.Time `json:"Created"`
-		LastWrite      time.Time `json:"LastWrite"`
-		LastSeen       time.Time `json:"LastSeen"`
-		LastHandshake  time.Time `json:"LastHandshake"`
-		Online         bool      `json:"Online"`
-		ExitNode       bool      `json:"ExitNode"`
-		ExitNodeOption bool      `json:"ExitNodeOption"`
-		Active         bool      `json:"Active"`
-		PeerAPIURL     []string  `json:"PeerAPIURL"`
-		Capabilities   []string  `json:"Capabilities"`
-		InNetworkMap   bool      `json:"InNetworkMap"`
-		InMagicSock    bool      `json:"InMagicSock"`
-		InEngine       bool      `json:"InEngine"`
-		KeyExpiry      time.Time `json:"KeyExpiry"`
-	} `json:"Peer"`
-	User map[string]struct {
-		ID            int    `json:"ID"`
-		LoginName     string `json:"LoginName"`
-		DisplayName   string `json:"DisplayName"`
-		ProfilePicURL string `json:"ProfilePicURL"`
-	} `json:"User"`
-	ClientVersion interface{} `json:"ClientVersion"`
-}
-type Collector struct {
-}
-
-var dynLabels = []string{"id", "name", "given_name", "ip", "peer_name", "peer_given_name", "peer_ip", "peer_user_id"}
-var PeerRxDesc = prometheus.NewDesc("tailscale_peer_rx", "", dynLabels, nil)
-var PeerTxDesc = prometheus.NewDesc("tailscale_peer_tx", "", dynLabels, nil)
+// set at build time by goreleaser ldflags
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
 
-func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- PeerTxDesc
-	ch <- PeerRxDesc
-}
+func main() {
+	flagConfig := Config{}
+	RegisterFlags(kingpin.CommandLine, &flagConfig)
+	logConfig := &promslog.Config{}
+	promslogflag.AddFlags(kingpin.CommandLine, logConfig)
+	configFile := kingpin.Flag("config.file", "YAML configuration file. Its keys override command line flags. Reloaded on SIGHUP or POST /-/reload.").Default("").String()
+	once := kingpin.Flag("once", "Collect once, print the metrics to stdout and exit. Exits non-zero when collection fails.").Default("false").Bool()
+	kingpin.Command("run", "Run the exporter.").Default()
+	recordCmd := kingpin.Command("record", "Write the current tailscale status as JSON, for replay with --tailscale.status-file.")
+	recordOutput := recordCmd.Flag("output", "File to write the status to, - for stdout.").Short('o').Default("-").String()
+	generateConfigCmd := kingpin.Command("generate-config", "Print a commented config file with the compiled-in defaults, for --config.file.").Alias("print-config")
+	checkConfigCmd := kingpin.Command("check-config", "Validate the flags and --config.file and check that tailscaled is reachable. Exits non-zero on any error.")
+	serviceMode := kingpin.Flag("service", "Windows service control: install registers the exporter with the current flags, uninstall removes it, run is used by the service manager. Use absolute paths, services start in the system directory.").Default("").Enum("", "install", "uninstall", "run")
+	kingpin.Version(fmt.Sprintf("tailscale-exporter %s (commit: %s, date: %s, %s)", version, commit, date, runtime.Version()))
+	kingpin.HelpFlag.Short('h')
+	command := kingpin.Parse()
 
-// Collect implements required collect function for all promehteus collectors
-func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	status, err := TailscaleGetStatus(ctx)
+	slog.SetDefault(promslog.New(logConfig))
+	if command == generateConfigCmd.FullCommand() {
+		if err := generateConfig(os.Stdout); err != nil {
+			slog.Error("error on generate config", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	cfg, err := LoadConfig(*configFile, flagConfig)
+	if command == checkConfigCmd.FullCommand() {
+		if !checkConfig(cfg, err) {
+			os.Exit(1)
+		}
+		return
+	}
 	if err != nil {
-		panic(err)
+		slog.Error("error on load config", "err", err)
+		os.Exit(1)
+	}
+	if cfg.Web.ConfigAPIPersist && *configFile == "" {
+		slog.Error("web.config-api-persist needs config.file")
+		os.Exit(1)
+	}
+	if *serviceMode == "install" || *serviceMode == "uninstall" {
+		control := installService
+		if *serviceMode == "uninstall" {
+			control = uninstallService
+		}
+		if err := control(); err != nil {
+			slog.Error("error on service control", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *serviceMode == "run" {
+		serviceCtx, serviceDone, err := runAsService(ctx)
+		if err != nil {
+			slog.Error("error on start service", "err", err)
+			os.Exit(1)
+		}
+		ctx = serviceCtx
+		defer serviceDone()
+	}
+	tailscaleclient.SetExecLimits(cfg.Tailscale.ExecConcurrency, cfg.Tailscale.ExecMinInterval)
+	collector.SetScrapeDeadlines(cfg.Collectors.ScrapeDeadline, cfg.Collectors.ScrapeDeadlines)
+	cli := tailscaleclient.CLI{Binary: cfg.Tailscale.Binary, Socket: cfg.Tailscale.Socket}
+	provider, localClient := newStatusProvider(cfg)
+	if command == recordCmd.FullCommand() {
+		if err := recordStatus(ctx, provider, *recordOutput, cfg.Tailscale.Timeout); err != nil {
+			slog.Error("error on record status", "err", err)
+			os.Exit(1)
+		}
+		return
 	}
-	templateLabels := make([]string, len(dynLabels))
-	templateLabels[0] = status.Self.ID
-	templateLabels[1] = status.Self.HostName
-	templateLabels[2] = strings.Split(status.Self.DNSName, ".")[0]
-	templateLabels[3] = status.Self.TailscaleIPs[0]
-	for _, peer := range status.Peer {
-		labels := slices.Clone(templateLabels)
-		labels[4] = peer.HostName
-		labels[5] = strings.Split(peer.DNSName, ".")[0]
-		labels[6] = peer.TailscaleIPs[0]
-		labels[7] = strconv.Itoa(peer.UserID)
 
-		ch <- prometheus.MustNewConstMetric(PeerRxDesc, prometheus.CounterValue, float64(peer.RxBytes), labels...)
-		ch <- prometheus.MustNewConstMetric(PeerTxDesc, prometheus.CounterValue, float64(peer.TxBytes), labels...)
+	registry := collector.NewSelectableRegistry()
+	if cfg.Collectors.Go {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+	if cfg.Collectors.Process {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	// metric names in this package omit the namespace, it is added here
+	var registerer prometheus.Registerer = registry
+	if cfg.Metrics.Namespace != "" {
+		registerer = prometheus.WrapRegistererWithPrefix(cfg.Metrics.Namespace+"_", registry)
+	}
+	// every output reads from gatherer, so label privacy applies everywhere
+	output := func(gatherer prometheus.Gatherer) prometheus.Gatherer {
+		if cfg.Labels.Redact || cfg.Labels.Hash {
+			gatherer = &collector.PrivacyGatherer{Gatherer: gatherer, Hash: cfg.Labels.Hash, Salt: cfg.Labels.HashSalt}
+		}
+		if len(cfg.Relabel) > 0 {
+			// validated with the config, after label privacy so rules can not bring identifying values back
+			gatherer, _ = collector.NewRelabelGatherer(gatherer, cfg.Relabel)
+		}
+		return gatherer
+	}
+	gatherer := output(registry)
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "exporter_build_info",
+		Help:        "Build of the exporter, always 1.",
+		ConstLabels: prometheus.Labels{"version": version, "commit": commit, "date": date, "goversion": runtime.Version()},
+	})
+	buildInfo.Set(1)
+	registerer.MustRegister(buildInfo)
+	startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_start_time_seconds",
+		Help: "Start time of the exporter since unix epoch.",
+	})
+	startTime.SetToCurrentTime()
+	registerer.MustRegister(startTime)
+	// the user metrics of tailscaled are already named, they skip the namespace
+	var rawRegisterer prometheus.Registerer = registry
+	// with several tailscaled every series tells them apart, the shared collectors are counted to the primary one
+	instanceRegisterer, instanceRawRegisterer := registerer, rawRegisterer
+	// the series of remote hosts carry instance_name too, empty, as their descriptors are shared
+	remoteRegisterer := registerer
+	if len(cfg.Tailscale.Instances) > 0 {
+		primaryLabels := prometheus.Labels{"instance_name": cfg.Tailscale.InstanceName}
+		registerer = prometheus.WrapRegistererWith(primaryLabels, registerer)
+		rawRegisterer = prometheus.WrapRegistererWith(primaryLabels, rawRegisterer)
+		remoteRegisterer = prometheus.WrapRegistererWith(prometheus.Labels{"instance_name": ""}, remoteRegisterer)
+	}
+	if len(cfg.SSH.Hosts) > 0 {
+		localLabels := prometheus.Labels{"remote_host": ""}
+		registerer = prometheus.WrapRegistererWith(localLabels, registerer)
+		rawRegisterer = prometheus.WrapRegistererWith(localLabels, rawRegisterer)
+		instanceRegisterer = prometheus.WrapRegistererWith(localLabels, instanceRegisterer)
+		instanceRawRegisterer = prometheus.WrapRegistererWith(localLabels, instanceRawRegisterer)
+	}
+	server.Version = version
+	registerer.MustRegister(tailscaleclient.StatusRetries, tailscaleclient.ExecInFlight, tailscaleclient.ExecWaitSeconds, server.RateLimitedRequests)
+	// tailnet connections of the exporter itself, through the tsnet node if there is one
+	tailnetClient := http.DefaultClient
+	var listener net.Listener
+	if cfg.Tsnet.Enabled {
+		upCtx, cancel := context.WithTimeout(ctx, cfg.Tsnet.UpTimeout)
+		ln, tsnetLocalClient, tsnetHTTPClient, err := server.StartTsnet(upCtx, cfg.Tsnet.Hostname, cfg.Tsnet.StateDir, "9995")
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+		listener, localClient, tailnetClient = ln, tsnetLocalClient, tsnetHTTPClient
+		provider = &tailscaleclient.LocalAPIProvider{Client: localClient}
+	} else if !cfg.Output.TextfileOnly && !*once {
+		rebindable, families, ips, err := server.NewWebListener(cfg.Web, provider, cfg.Tailscale.Timeout)
+		if err != nil {
+			panic(err)
+		}
+		listener = rebindable
+		rebinds := prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_rebinds_total",
+			Help: "Number of times the listener moved to a new Tailscale IP.",
+		})
+		registerer.MustRegister(rebinds)
+		go server.WatchListenAddrs(ctx, provider, families, ips, cfg.Tailscale.Timeout, func(family string, newIp string) error {
+			newListener, err := net.Listen("tcp", net.JoinHostPort(newIp, "9995"))
+			if err != nil {
+				return err
+			}
+			rebindable.Rebind(family, newListener)
+			rebinds.Inc()
+			if cfg.Serve.Enabled {
+				// serve proxies to the old address otherwise
+				return server.ServeMetrics(ctx, cfg.Serve, cfg.Tailscale.Timeout, localClient, provider, listener.Addr().String())
+			}
+			return nil
+		})
+	}
+	if cfg.Serve.Enabled && !*once {
+		if err := server.ServeMetrics(ctx, cfg.Serve, cfg.Tailscale.Timeout, localClient, provider, listener.Addr().String()); err != nil {
+			panic(err)
+		}
+	}
+	// in --once mode background loops run a single iteration up front instead
+	onceFailed := false
+	transitions := collector.NewTransitionTracker()
+	transitions.AggregatePeers = cfg.Metrics.AggregateOnly
+	transitions.SetPeerFilter(cfg.Peers)
+	registerer.MustRegister(transitions)
+	if cfg.Status.WatchIPNBus {
+		watcher := &tailscaleclient.StatusWatcher{LocalClient: localClient, ResyncInterval: cfg.Status.ResyncInterval, Timeout: cfg.Tailscale.Timeout, MaxAge: cfg.Status.MaxAge, Observe: transitions.Observe}
+		if *once {
+			onceFailed = watcher.Resync(ctx) != nil
+		} else {
+			go watcher.Run(ctx)
+		}
+		provider = watcher
+	}
+	health := &server.Health{}
+	provider = health.Track(transitions.Track(provider))
+	go server.RunWatchdog(ctx, provider)
+	switch {
+	case cfg.Status.WatchIPNBus:
+		// kept in memory by the watcher
+	case cfg.Status.RefreshInterval > 0:
+		cache := &tailscaleclient.StatusCache{Provider: provider, Interval: cfg.Status.RefreshInterval, Timeout: cfg.Tailscale.Timeout, MaxAge: cfg.Status.MaxAge}
+		if *once {
+			onceFailed = cache.Update(ctx) != nil
+		} else {
+			go cache.Run(ctx)
+		}
+		registerer.MustRegister(cache)
+		provider = cache
+	default:
+		provider = tailscaleclient.SharedStatus(provider, cfg.Tailscale.Timeout)
 	}
+	peerCollector := newPeerCollector(cfg, provider, cfg.Tailscale.Timeout)
+	if cfg.Collectors.Accumulate {
+		peerCollector.Accumulator = startAccumulator(ctx, cfg.Collectors.AccumulateFile)
+	}
+	if cfg.Collectors.Peers {
+		registry.MustRegisterScrape(registerer, "peers", peerCollector)
+	}
+	if cfg.Netcheck.Enabled {
+		netcheckCollector := &collector.NetcheckCollector{CLI: cli, Interval: cfg.Netcheck.Interval, Timeout: cfg.Netcheck.Timeout}
+		if *once {
+			if err := netcheckCollector.Update(ctx); err != nil {
+				slog.Error("netcheck failed", "err", err)
+				onceFailed = true
+			}
+		} else {
+			go netcheckCollector.Run(ctx)
+		}
+		registry.MustRegisterScrape(registerer, "netcheck", netcheckCollector)
+	}
+	prober := collector.NewPeerProber(cfg.Probe)
+	prober.CLI = cli
+	prober.Provider = provider
+	if !cfg.Tsnet.Enabled && cfg.Collectors.Probes {
+		if *once {
+			prober.ProbeAll(ctx)
+		} else {
+			// always running, so probe targets can be added by config reload
+			go prober.Run(ctx)
+		}
+		registry.MustRegisterScrape(registerer, "probes", prober)
+	}
+	registerLocalAPICollectors(registry, registerer, rawRegisterer, cfg, localClient)
+	peerCollectors := []*collector.PeerCollector{peerCollector}
+	trackers := []*collector.TransitionTracker{transitions}
+	for name, socket := range cfg.Tailscale.Instances {
+		labels := prometheus.Labels{"instance_name": name}
+		instanceCollector, instanceTransitions, failed := startInstance(ctx, cfg, registry, name, socket, prometheus.WrapRegistererWith(labels, instanceRegisterer), prometheus.WrapRegistererWith(labels, instanceRawRegisterer), *once)
+		peerCollectors = append(peerCollectors, instanceCollector)
+		trackers = append(trackers, instanceTransitions)
+		onceFailed = onceFailed || failed
+	}
+	if len(cfg.SSH.Hosts) > 0 {
+		sshConfig, err := tailscaleclient.NewSSHClientConfig(cfg.SSH.User, cfg.SSH.KeyFile, cfg.SSH.KnownHostsFile, cfg.SSH.Timeout)
+		if err != nil {
+			panic(err)
+		}
+		// through the tsnet node if there is one, the tailnet addresses are not routed on the host otherwise
+		var dial func(ctx context.Context, network string, address string) (net.Conn, error)
+		if transport, ok := tailnetClient.Transport.(*http.Transport); ok {
+			dial = transport.DialContext
+		}
+		for name, address := range cfg.SSH.Hosts {
+			provider := &tailscaleclient.SSHProvider{Address: address, Config: sshConfig, Command: cfg.SSH.Command, Dial: dial}
+			remoteCollector := newPeerCollector(cfg, tailscaleclient.SharedStatus(provider, cfg.SSH.Timeout), cfg.SSH.Timeout)
+			registry.MustRegisterScrape(prometheus.WrapRegistererWith(prometheus.Labels{"remote_host": name}, remoteRegisterer), "peers", remoteCollector)
+			peerCollectors = append(peerCollectors, remoteCollector)
+		}
+	}
+	if cfg.Collectors.Cert {
+		registry.MustRegisterScrape(registerer, "cert", &collector.CertCollector{Dir: cfg.Collectors.CertDir})
+	}
+	if cfg.Collectors.NetDev {
+		registry.MustRegisterScrape(registerer, "netdev", &collector.NetDevCollector{Path: cfg.Collectors.NetDevPath, Interface: cfg.Collectors.NetDevInterface})
+	}
+	if cfg.Collectors.Forwarding {
+		registry.MustRegisterScrape(registerer, "forwarding", &collector.ForwardingCollector{Provider: provider, Timeout: cfg.Tailscale.Timeout, ProcPath: cfg.Collectors.DaemonProcPath})
+	}
+	if cfg.Collectors.Version {
+		registry.MustRegisterScrape(registerer, "version", &collector.VersionCollector{CLI: cli, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.TailscaledStart {
+		registry.MustRegisterScrape(registerer, "tailscaled_start", &collector.TailscaledStartCollector{ProcPath: cfg.Collectors.DaemonProcPath})
+	}
+	if cfg.Collectors.Daemon {
+		registry.MustRegisterScrape(registerer, "daemon", &collector.DaemonCollector{ProcPath: cfg.Collectors.DaemonProcPath})
+	}
+	if cfg.Collectors.AdminAPI && (cfg.AdminAPI.Key != "" || cfg.AdminAPI.OAuthClientID != "") {
+		adminAPIClient := collector.NewAdminAPIClient(cfg.AdminAPI.BaseURL, cfg.AdminAPI.Tailnet, cfg.AdminAPI.Key, cfg.AdminAPI.OAuthClientID, cfg.AdminAPI.OAuthClientSecret)
+		registry.MustRegisterScrape(registerer, "admin_api", &collector.AdminAPICollector{Client: adminAPIClient, Timeout: cfg.AdminAPI.Timeout, PostureAttributes: cfg.AdminAPI.PostureAttributes})
+		if cfg.Collectors.Policy {
+			registry.MustRegisterScrape(registerer, "policy", &collector.PolicyCollector{Client: adminAPIClient, Timeout: cfg.AdminAPI.Timeout})
+		}
+		if cfg.Collectors.Keys {
+			registry.MustRegisterScrape(registerer, "keys", &collector.KeysCollector{Client: adminAPIClient, Timeout: cfg.AdminAPI.Timeout, ExpiryWindow: cfg.Collectors.KeysExpiryWindow})
+		}
+		if cfg.Collectors.TailnetDNS {
+			registry.MustRegisterScrape(registerer, "tailnet_dns", &collector.TailnetDNSCollector{Client: adminAPIClient, Timeout: cfg.AdminAPI.Timeout})
+		}
+	}
+	if cfg.Collectors.Headscale && cfg.Headscale.URL != "" {
+		registry.MustRegisterScrape(registerer, "headscale", &collector.HeadscaleCollector{Client: &collector.HeadscaleClient{BaseURL: cfg.Headscale.URL, APIKey: cfg.Headscale.APIKey}, Timeout: cfg.Headscale.Timeout})
+	}
+	if *once {
+		families, err := gatherer.Gather()
+		if err != nil {
+			slog.Error("error on gather metrics", "err", err)
+			onceFailed = true
+		}
+		if err := server.WriteMetrics(os.Stdout, families); err != nil {
+			slog.Error("error on write metrics", "err", err)
+			onceFailed = true
+		}
+		if cfg.Collectors.Peers && (health.LastErr() != nil || health.LastSuccess().IsZero()) {
+			onceFailed = true
+		}
+		if onceFailed {
+			os.Exit(1)
+		}
+		return
+	}
+	accessControl := &server.AccessControl{LocalClient: localClient}
+	accessControl.SetPolicy(cfg.Access)
 
-}
+	mux := http.NewServeMux()
+	if cfg.Collectors.Webhook && cfg.Webhook.Secret != "" {
+		webhookReceiver := collector.NewWebhookReceiver(cfg.Webhook.Secret)
+		registerer.MustRegister(webhookReceiver)
+		go server.ServeWebhook(cfg.Webhook.ListenAddress, webhookReceiver)
+	}
+	applyLive := func(cfg Config) {
+		for _, peerCollector := range peerCollectors {
+			peerCollector.SetPeerFilter(cfg.Peers)
+		}
+		for _, tracker := range trackers {
+			tracker.SetPeerFilter(cfg.Peers)
+		}
+		prober.SetTargets(cfg.Probe)
+		accessControl.SetPolicy(cfg.Access)
+	}
+	authenticator := &server.Authenticator{Config: cfg.Auth}
+	configAPI := NewConfigAPI(*configFile, cfg.Web.ConfigAPIPersist, cfg, applyLive)
+	if cfg.Web.EnableConfigAPI {
+		mux.Handle("/-/config", authenticator.Wrap(configAPI))
+	}
+	if *configFile != "" {
+		reloader := NewReloader(*configFile, flagConfig, cfg, func(cfg Config) {
+			applyLive(cfg)
+			configAPI.SetCurrent(cfg)
+		})
+		go reloader.WatchSIGHUP()
+		mux.Handle("/-/reload", authenticator.Wrap(reloader))
+	}
 
-func TailscaleGetStatus(ctx context.Context) (*TailscaleStatus, error) {
-	stdout := bytes.NewBuffer(nil)
-	stderr := bytes.NewBuffer(nil)
-	cmd := exec.CommandContext(ctx, "tailscale", "status", "-json")
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	err := cmd.Run()
+	rateLimiter := &server.RateLimiter{Requests: cfg.Web.RateLimitRequests, Interval: cfg.Web.RateLimitInterval}
+	metricsOpts := promhttp.HandlerOpts{MaxRequestsInFlight: cfg.Web.MaxRequestsInFlight, EnableOpenMetrics: cfg.Web.EnableOpenMetrics, EnableOpenMetricsTextCreatedSamples: cfg.Web.EnableOpenMetrics}
+	// ?collect[]=name selects collectors by the collector label of exporter_scrape_success, ?format= the exposition format
+	mux.Handle("/metrics", rateLimiter.Wrap(authenticator.Wrap(promhttp.InstrumentMetricHandler(registry, server.FormatHandler(server.CollectHandler(registry, output, metricsOpts, promhttp.HandlerFor(gatherer, metricsOpts)))))))
+	if cfg.Web.EnableInflux {
+		mux.Handle("/influx", rateLimiter.Wrap(authenticator.Wrap(server.InfluxHandler(gatherer))))
+	}
+	if cfg.Web.EnableDashboard {
+		mux.Handle("/dashboard.json", authenticator.Wrap(server.DashboardHandler(gatherer)))
+	}
+	if cfg.Web.EnableJSONAPI {
+		mux.Handle("/api/v1/metrics", authenticator.Wrap(server.JSONMetricsHandler(gatherer)))
+	}
+	if cfg.Web.EnableSD {
+		mux.Handle("/sd", authenticator.Wrap(server.SDHandler(provider, cfg.Web.SDPort)))
+	}
+	if cfg.Federate.Enabled {
+		registerer.MustRegister(server.FederateUp, server.FederateScrapeDuration)
+		var federation prometheus.Gatherer = &server.FederationGatherer{
+			Provider:   provider,
+			Filter:     server.SDFilter{Tags: cfg.Federate.Tags, OnlineOnly: true},
+			Port:       cfg.Federate.Port,
+			Path:       cfg.Federate.Path,
+			Timeout:    cfg.Federate.Timeout,
+			HTTPClient: tailnetClient,
+		}
+		if cfg.Labels.Redact || cfg.Labels.Hash {
+			federation = &collector.PrivacyGatherer{Gatherer: federation, Hash: cfg.Labels.Hash, Salt: cfg.Labels.HashSalt}
+		}
+		mux.Handle("/federate", authenticator.Wrap(server.FormatHandler(promhttp.HandlerFor(federation, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError, EnableOpenMetrics: cfg.Web.EnableOpenMetrics}))))
+	}
+	if cfg.Debug.EnableStatus {
+		mux.Handle("/debug/status", authenticator.Wrap(health.StatusHandler(provider, cfg.Debug.StatusRedact || cfg.Labels.Redact || cfg.Labels.Hash)))
+	}
+	mux.HandleFunc("/healthz", server.HealthzHandler)
+	mux.Handle("/readyz", health.ReadyzHandler(cfg.Web.ReadyMaxAge, provider))
+	landingLinks := []web.LandingLinks{
+		{Address: "/metrics", Text: "Metrics"},
+		{Address: "/healthz", Text: "Health", Description: "Process is alive"},
+		{Address: "/readyz", Text: "Readiness", Description: "tailscaled is reachable"},
+	}
+	if cfg.Web.EnableInflux {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/influx", Text: "InfluxDB line protocol"})
+	}
+	if cfg.Federate.Enabled {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/federate", Text: "Federation", Description: "Merged metrics of the peer exporters"})
+	}
+	if cfg.Web.EnableDashboard {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/dashboard.json", Text: "Grafana dashboard", Description: "Dashboard matching the exported metrics, for import"})
+	}
+	if cfg.Web.EnableJSONAPI {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/api/v1/metrics", Text: "JSON", Description: "Metrics grouped into self, peers and aggregates"})
+	}
+	if cfg.Web.EnableSD {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/sd", Text: "Service discovery", Description: "Tailnet nodes in Prometheus HTTP SD format"})
+	}
+	if cfg.Debug.EnableStatus {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/debug/status", Text: "Status", Description: "Last fetched tailscale status the metrics are derived from"})
+	}
+	if !cfg.Tsnet.Enabled {
+		landingLinks = append(landingLinks, web.LandingLinks{Address: "/probe?target=", Text: "Probe", Description: "On-demand ping or tcp check of a peer"})
+	}
+	landingPage, err := web.NewLandingPage(web.LandingConfig{
+		Name:        "Tailscale Exporter",
+		Description: "Prometheus exporter for Tailscale",
+		Version:     version + " (commit: " + commit + ")",
+		Links:       landingLinks,
+		Profiling:   "false",
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error on headscale nodes list: %w. stderr: %s", err, stderr.String())
+		panic(err)
+	}
+	mux.Handle("/", landingPage)
+	if !cfg.Tsnet.Enabled {
+		mux.Handle("/probe", rateLimiter.Wrap(authenticator.Wrap(server.ProbeHandler(cli, cfg.Probe.Timeout))))
+	}
+	if cfg.OTLP.Endpoint != "" {
+		meterProvider, err := server.StartOTLP(ctx, cfg.OTLP, gatherer)
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := meterProvider.Shutdown(shutdownCtx); err != nil {
+				slog.Error("error on otlp shutdown", "err", err)
+			}
+		}()
+	}
+	if cfg.RemoteWrite.URL != "" {
+		hostname, _ := os.Hostname()
+		labels := map[string]string{"job": "tailscale-exporter", "instance": hostname}
+		maps.Copy(labels, cfg.RemoteWrite.Labels)
+		remoteWriter := server.NewRemoteWriter(cfg.RemoteWrite, gatherer, labels)
+		go remoteWriter.Run(ctx)
+	}
+	if cfg.Push.URL != "" {
+		if cfg.Push.Instance == "" {
+			cfg.Push.Instance, _ = os.Hostname()
+		}
+		go server.NewPushgateway(cfg.Push, gatherer).Run(ctx)
+	}
+	if cfg.StatsD.Address != "" {
+		go server.NewStatsDEmitter(cfg.StatsD, gatherer).Run(ctx)
+	}
+	if cfg.Output.TextfileDir != "" {
+		textfileWriter := &server.TextfileWriter{Gatherer: gatherer, Dir: cfg.Output.TextfileDir, Interval: cfg.Output.TextfileInterval}
+		go textfileWriter.Run(ctx)
+	}
+	if cfg.Output.FileSDPath != "" {
+		fileSDWriter := &server.FileSDWriter{Provider: provider, Path: cfg.Output.FileSDPath, Port: cfg.Output.FileSDPort, Filter: server.SDFilter{Tags: cfg.Output.FileSDTags}, Interval: cfg.Output.FileSDInterval, Timeout: cfg.Tailscale.Timeout}
+		go fileSDWriter.Run(ctx)
+	}
+	if cfg.Output.TextfileOnly {
+		slog.Info("start application!", "textfile_dir", cfg.Output.TextfileDir)
+		server.NotifySystemd(daemon.SdNotifyReady)
+		<-ctx.Done()
+		server.NotifySystemd(daemon.SdNotifyStopping)
+		return
+	}
+	if rebindable, ok := listener.(*server.RebindableListener); ok {
+		slog.Info("start application!", "addresses", strings.Join(rebindable.Addrs(), ", "))
+	} else {
+		slog.Info("start application!", "address", listener.Addr().String())
+	}
+	httpServer := &http.Server{
+		Handler:           accessControl.Middleware(mux),
+		ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		WriteTimeout:      cfg.Web.WriteTimeout,
+		IdleTimeout:       cfg.Web.IdleTimeout,
+	}
+	if cfg.Web.MaxConnections > 0 {
+		listener = netutil.LimitListener(listener, cfg.Web.MaxConnections)
+	}
+	if cfg.Web.TLSCertFile != "" {
+		listener = tls.NewListener(listener, server.MTLSConfig(cfg.Web.TLSCertFile, cfg.Web.TLSKeyFile, cfg.Web.TLSClientCAFile))
+		// web.Serve only knows about TLS from web.config.file and logs it as disabled
+		slog.Info("TLS is enabled.", "client_certificates_required", cfg.Web.TLSClientCAFile != "")
+	}
+	if cfg.Debug.EnablePprof {
+		go server.ServeDebug(cfg.Debug.ListenAddress)
+	}
+	if cfg.Web.StartupTimeout > 0 {
+		health.StartupDeadline = time.Now().Add(cfg.Web.StartupTimeout)
+		waitFirstStatus := func() {
+			if err := health.WaitFirstStatus(ctx, provider, cfg.Tailscale.Timeout); err != nil {
+				slog.Warn("starting without a successful status", "err", err)
+			}
+		}
+		if cfg.Web.StartupDelayServe {
+			slog.Info("waiting for the first successful status before serving", "timeout", cfg.Web.StartupTimeout)
+			waitFirstStatus()
+		} else {
+			go waitFirstStatus()
+		}
+	}
+	server.NotifySystemd(daemon.SdNotifyReady)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- web.Serve(listener, httpServer, &web.FlagConfig{WebConfigFile: &cfg.Web.ConfigFile}, slog.Default())
+	}()
+	select {
+	case err := <-serveErr:
+		slog.Error("error on serve", "err", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down, waiting for in-flight requests")
+	server.NotifySystemd(daemon.SdNotifyStopping)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error on shutdown", "err", err)
+	}
+	if cfg.Serve.Enabled {
+		if err := server.UnserveMetrics(context.Background(), cfg.Serve, cfg.Tailscale.Timeout, localClient, provider); err != nil {
+			slog.Error("error on remove tailscale serve handler", "err", err)
+		}
 	}
-	status := TailscaleStatus{}
-	if err := json.Unmarshal(stdout.Bytes(), &status); err != nil {
-		return nil, fmt.Errorf("error on unmarshal: %w. stdout: %s", err, stdout.String())
+	for _, peerCollector := range peerCollectors {
+		if peerCollector.Accumulator != nil {
+			if err := peerCollector.Accumulator.Save(); err != nil {
+				slog.Error("error on save counter state", "err", err)
+			}
+		}
 	}
-	return &status, nil
 }
 
-func getListenAddr() (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	status, err := TailscaleGetStatus(ctx)
-	if err != nil {
-		return "", err
+// newStatusProvider returns the status source of the primary tailscaled and its LocalAPI client.
+func newStatusProvider(cfg Config) (tailscaleclient.StatusProvider, *local.Client) {
+	cli := tailscaleclient.CLI{Binary: cfg.Tailscale.Binary, Socket: cfg.Tailscale.Socket}
+	localClient := &local.Client{Socket: cfg.Tailscale.Socket, UseSocketOnly: cfg.Tailscale.Socket != ""}
+	var provider tailscaleclient.StatusProvider = &tailscaleclient.ExecProvider{CLI: cli, Retries: cfg.Tailscale.Retries}
+	if cfg.Tailscale.MacOSGUI {
+		// the GUI clients do not ship the tailscale CLI on the PATH
+		localClient = &local.Client{Transport: &tailscaleclient.SameUserProofTransport{Dir: cfg.Tailscale.SameUserProofDir}, OmitAuth: true}
+		provider = &tailscaleclient.LocalAPIProvider{Client: localClient}
 	}
+	if cfg.Tailscale.StatusFile != "" {
+		provider = &tailscaleclient.FileProvider{Path: cfg.Tailscale.StatusFile, Reload: cfg.Tailscale.StatusFileReload}
+	}
+	return provider, localClient
+}
 
-	ips := status.Self.TailscaleIPs
-	if len(ips) < 1 {
-		return "", fmt.Errorf("no ips found")
+// newPeerCollector returns a PeerCollector of provider with the peer options and filter of cfg.
+func newPeerCollector(cfg Config, provider tailscaleclient.StatusProvider, timeout time.Duration) *collector.PeerCollector {
+	peerCollector := &collector.PeerCollector{
+		Provider:        provider,
+		Timeout:         timeout,
+		RouteInfo:       cfg.Collectors.RouteInfo,
+		KeyExpiryWindow: cfg.Collectors.KeyExpiryWindow,
+		AggregateOnly:   cfg.Metrics.AggregateOnly,
+		UserTraffic:     cfg.Collectors.UserTraffic,
+		TagTraffic:      cfg.Collectors.TagTraffic,
+		GracePeriod:     cfg.Collectors.PeerGracePeriod,
+		RateInterval:    cfg.Collectors.PeerRateInterval,
 	}
+	peerCollector.SetPeerFilter(cfg.Peers)
+	return peerCollector
+}
 
-	return ips[0], nil
+// startAccumulator loads the peer byte counters saved at path and keeps saving them until ctx is done.
+func startAccumulator(ctx context.Context, path string) *collector.CounterAccumulator {
+	accumulator := &collector.CounterAccumulator{Path: path, Interval: time.Minute}
+	if err := accumulator.Load(); err != nil {
+		// starting over only loses the adjustment, not the raw counters
+		slog.Error("error on load counter state", "err", err)
+	}
+	go accumulator.Run(ctx)
+	return accumulator
 }
 
-func main() {
-	ip, err := getListenAddr()
-	if err != nil {
-		panic(err)
+// registerLocalAPICollectors registers the enabled collectors reading the LocalAPI of one tailscaled.
+// rawRegisterer skips the metrics namespace.
+func registerLocalAPICollectors(registry *collector.SelectableRegistry, registerer prometheus.Registerer, rawRegisterer prometheus.Registerer, cfg Config, localClient *local.Client) {
+	if cfg.Collectors.Prefs {
+		registry.MustRegisterScrape(registerer, "prefs", &collector.PrefsCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
 	}
-	listen := ip + ":9995"
-	go func() {
-		errors := 0
-		for {
-			newIp, err := getListenAddr()
-			if err != nil {
-				errors++
-				if errors > 20 {
-					panic(fmt.Errorf("on update ip: " + err.Error()))
-				}
-				continue
-			}
-			if newIp != ip {
-				log.Fatalf("found new ip. was: %s, now: %s", ip, newIp)
-			}
-			time.Sleep(time.Second * 20)
+	if cfg.Collectors.SSH {
+		registry.MustRegisterScrape(registerer, "ssh", &collector.SSHCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.Serve {
+		registry.MustRegisterScrape(registerer, "serve", &collector.ServeCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.TailnetLock {
+		registry.MustRegisterScrape(registerer, "tailnet_lock", &collector.TailnetLockCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.Taildrop {
+		registry.MustRegisterScrape(registerer, "taildrop", &collector.TaildropCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.Taildrive {
+		registry.MustRegisterScrape(registerer, "taildrive", &collector.TaildriveCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.DNS {
+		registry.MustRegisterScrape(registerer, "dns", &collector.DNSCollector{LocalClient: localClient, Timeout: cfg.Tailscale.Timeout})
+	}
+	if cfg.Collectors.UserMetrics {
+		registry.MustRegisterRawScrape(rawRegisterer, cfg.Metrics.Namespace, "usermetrics", &collector.UserMetricsCollector{LocalClient: localClient, Prefix: cfg.Collectors.UserMetricsPrefix, Timeout: cfg.Tailscale.Timeout})
+	}
+}
+
+// startInstance monitors an additional tailscaled listening on socket with the peer and LocalAPI collectors,
+// through registerers adding its instance_name. It returns the peer collector, the transition tracker and, in once mode, whether its status failed.
+func startInstance(ctx context.Context, cfg Config, registry *collector.SelectableRegistry, name string, socket string, registerer prometheus.Registerer, rawRegisterer prometheus.Registerer, once bool) (*collector.PeerCollector, *collector.TransitionTracker, bool) {
+	localClient := &local.Client{Socket: socket, UseSocketOnly: true}
+	failed := false
+	transitions := collector.NewTransitionTracker()
+	transitions.AggregatePeers = cfg.Metrics.AggregateOnly
+	transitions.SetPeerFilter(cfg.Peers)
+	registerer.MustRegister(transitions)
+	var provider tailscaleclient.StatusProvider = &tailscaleclient.ExecProvider{CLI: tailscaleclient.CLI{Binary: cfg.Tailscale.Binary, Socket: socket}, Retries: cfg.Tailscale.Retries}
+	if cfg.Status.WatchIPNBus {
+		watcher := &tailscaleclient.StatusWatcher{LocalClient: localClient, ResyncInterval: cfg.Status.ResyncInterval, Timeout: cfg.Tailscale.Timeout, MaxAge: cfg.Status.MaxAge, Observe: transitions.Observe}
+		if once {
+			failed = watcher.Resync(ctx) != nil
+		} else {
+			go watcher.Run(ctx)
 		}
-	}()
-	prometheus.MustRegister(&Collector{})
+		provider = watcher
+	}
+	provider = transitions.Track(provider)
+	switch {
+	case cfg.Status.WatchIPNBus:
+	case cfg.Status.RefreshInterval > 0:
+		cache := &tailscaleclient.StatusCache{Provider: provider, Interval: cfg.Status.RefreshInterval, Timeout: cfg.Tailscale.Timeout, MaxAge: cfg.Status.MaxAge}
+		if once {
+			failed = cache.Update(ctx) != nil
+		} else {
+			go cache.Run(ctx)
+		}
+		registerer.MustRegister(cache)
+		provider = cache
+	default:
+		provider = tailscaleclient.SharedStatus(provider, cfg.Tailscale.Timeout)
+	}
+	peerCollector := newPeerCollector(cfg, provider, cfg.Tailscale.Timeout)
+	if cfg.Collectors.Accumulate {
+		path := cfg.Collectors.AccumulateFile
+		if path != "" {
+			path += "." + name
+		}
+		peerCollector.Accumulator = startAccumulator(ctx, path)
+	}
+	if cfg.Collectors.Peers {
+		registry.MustRegisterScrape(registerer, "peers", peerCollector)
+	}
+	registerLocalAPICollectors(registry, registerer, rawRegisterer, cfg, localClient)
+	return peerCollector, transitions, failed
+}
 
-	http.Handle("/metrics", promhttp.Handler())
-	log.Println("start application! " + listen)
-	log.Fatal(http.ListenAndServe(listen, nil))
+// recordStatus writes the status of provider as indented JSON to path, stdout for "-".
+func recordStatus(ctx context.Context, provider tailscaleclient.StatusProvider, path string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	status, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error on marshal status: %w", err)
+	}
+	content = append(content, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
 }
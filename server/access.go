@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// AccessPolicy allows requests from tailnet identities resolved with LocalAPI WhoIs.
+// A request is allowed if it matches any of the configured tags, users or capabilities.
+type AccessPolicy struct {
+	AllowTags         []string `yaml:"allow_tags"`
+	AllowUsers        []string `yaml:"allow_users"`
+	AllowCapabilities []string `yaml:"allow_capabilities"`
+}
+
+func (policy *AccessPolicy) Enabled() bool {
+	return len(policy.AllowTags) > 0 || len(policy.AllowUsers) > 0 || len(policy.AllowCapabilities) > 0
+}
+
+func (policy *AccessPolicy) Allowed(who *apitype.WhoIsResponse) bool {
+	if who.Node == nil {
+		return false
+	}
+	for _, tag := range who.Node.Tags {
+		if slices.Contains(policy.AllowTags, tag) {
+			return true
+		}
+	}
+	if who.UserProfile != nil && !who.Node.IsTagged() && slices.Contains(policy.AllowUsers, who.UserProfile.LoginName) {
+		return true
+	}
+	for _, capability := range policy.AllowCapabilities {
+		if who.CapMap.HasCapability(tailcfg.PeerCapability(capability)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessControl holds the current AccessPolicy, which can be replaced on config reload.
+type AccessControl struct {
+	LocalClient *local.Client
+
+	mu     sync.RWMutex
+	policy AccessPolicy
+}
+
+func (control *AccessControl) SetPolicy(policy AccessPolicy) {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	control.policy = policy
+}
+
+func (control *AccessControl) Policy() AccessPolicy {
+	control.mu.RLock()
+	defer control.mu.RUnlock()
+	return control.policy
+}
+
+// Middleware enforces the policy on every endpoint except health checks, which orchestrators call from outside the tailnet.
+// Requests over the unix socket have no tailnet identity and are guarded by socket file permissions instead.
+func (control *AccessControl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := control.Policy()
+		_, unixSocket := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+		if !policy.Enabled() || unixSocket || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		who, err := control.LocalClient.WhoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			slog.Warn("whois failed", "remote_addr", r.RemoteAddr, "err", err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !policy.Allowed(who) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
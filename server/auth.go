@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// AuthConfig protects /metrics and /probe with HTTP basic auth or static bearer tokens.
+// Either one is enough, none configured allows everyone.
+type AuthConfig struct {
+	// BasicAuthUsers maps user names to bcrypt password hashes
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	BearerTokens   []string          `yaml:"bearer_tokens"`
+}
+
+func (auth AuthConfig) Enabled() bool {
+	return len(auth.BasicAuthUsers) > 0 || len(auth.BearerTokens) > 0
+}
+
+// Authenticator checks requests against an AuthConfig.
+// bcrypt is slow by design, so verified credentials are remembered by their sha256.
+type Authenticator struct {
+	Config AuthConfig
+
+	verified sync.Map
+}
+
+func (authenticator *Authenticator) allowed(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, bearerToken := range authenticator.Config.BearerTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(bearerToken)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := authenticator.Config.BasicAuthUsers[user]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	if _, ok := authenticator.verified.Load(key); ok {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	authenticator.verified.Store(key, true)
+	return true
+}
+
+// Wrap requires authentication for next when any credentials are configured.
+func (authenticator *Authenticator) Wrap(next http.Handler) http.Handler {
+	if !authenticator.Config.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authenticator.allowed(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tailscale-exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
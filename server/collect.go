@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"tailscale-exporter/collector"
+)
+
+// CollectHandler serves next, or for requests with collect[] parameters only the named collectors of registry,
+// node_exporter style, so jobs with different scrape intervals can share one exporter.
+// output applies the same label privacy and relabeling as for the unfiltered metrics.
+func CollectHandler(registry *collector.SelectableRegistry, output func(prometheus.Gatherer) prometheus.Gatherer, opts promhttp.HandlerOpts, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		selected, err := registry.Select(names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		promhttp.HandlerFor(output(selected), opts).ServeHTTP(w, r)
+	})
+}
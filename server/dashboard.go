@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// dashboardSkipLabels identify a series but make poor legends, the name labels next to them are used instead.
+var dashboardSkipLabels = map[string]bool{
+	"id": true, "ip": true, "given_name": true, "peer_ip": true, "peer_given_name": true, "peer_user_id": true, "user_id": true,
+}
+
+// DashboardHandler serves a Grafana dashboard with one panel per gathered metric, so it always matches
+// the names, namespace and labels of the running exporter. Legends show the labels varying between series.
+func DashboardHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			slog.Error("error on gather metrics", "err", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(dashboard(families)); err != nil {
+			slog.Error("error on write dashboard", "err", err)
+		}
+	})
+}
+
+func dashboard(families []*dto.MetricFamily) map[string]any {
+	datasource := map[string]any{"type": "prometheus", "uid": "${datasource}"}
+	instanceQuery, selector := "", ""
+	for _, family := range families {
+		if strings.HasSuffix(family.GetName(), "exporter_build_info") {
+			instanceQuery = fmt.Sprintf("label_values(%s, instance)", family.GetName())
+			selector = `{instance=~"$instance"}`
+		}
+	}
+	var panels []any
+	for _, family := range families {
+		expr, unit, title := dashboardQuery(family, selector)
+		if expr == "" {
+			continue
+		}
+		legend := "__auto"
+		if labels := varyingLabels(family); len(labels) > 0 {
+			legend = "{{" + strings.Join(labels, "}} {{") + "}}"
+		}
+		panels = append(panels, map[string]any{
+			"type":        "timeseries",
+			"title":       title,
+			"description": family.GetHelp(),
+			"datasource":  datasource,
+			"gridPos":     map[string]int{"x": len(panels) % 2 * 12, "y": len(panels) / 2 * 8, "w": 12, "h": 8},
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": unit}, "overrides": []any{}},
+			"targets":     []any{map[string]any{"refId": "A", "datasource": datasource, "expr": expr, "legendFormat": legend}},
+		})
+	}
+	variables := []any{map[string]any{"name": "datasource", "label": "Data source", "type": "datasource", "query": "prometheus"}}
+	if instanceQuery != "" {
+		variables = append(variables, map[string]any{
+			"name": "instance", "label": "Instance", "type": "query", "datasource": datasource,
+			"query": instanceQuery, "refresh": 2, "multi": true, "includeAll": true, "allValue": ".*",
+		})
+	}
+	return map[string]any{
+		"uid":           "tailscale-exporter",
+		"title":         "Tailscale Exporter",
+		"tags":          []string{"tailscale"},
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"refresh":       "1m",
+		"templating":    map[string]any{"list": variables},
+		"panels":        panels,
+	}
+}
+
+// dashboardQuery returns the PromQL, unit and title of the panel of family, an empty query for no panel.
+// selector is appended to the metric names.
+func dashboardQuery(family *dto.MetricFamily, selector string) (string, string, string) {
+	name := family.GetName()
+	// runtime metrics have their own dashboards, info series are always 1
+	if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") || strings.HasPrefix(name, "promhttp_") || strings.HasSuffix(name, "_info") {
+		return "", "", ""
+	}
+	by := "sum"
+	if labels := varyingLabels(family); len(labels) > 0 {
+		by = "sum by (" + strings.Join(labels, ", ") + ")"
+	}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		unit := "ops"
+		if strings.Contains(name, "bytes") {
+			unit = "Bps"
+		} else if strings.Contains(name, "seconds") {
+			unit = "s"
+		}
+		return fmt.Sprintf("%s (rate(%s%s[$__rate_interval]))", by, name, selector), unit, name + " per second"
+	case dto.MetricType_HISTOGRAM:
+		labels := append([]string{"le"}, varyingLabels(family)...)
+		return fmt.Sprintf("histogram_quantile(0.9, sum by (%s) (rate(%s_bucket%s[$__rate_interval])))", strings.Join(labels, ", "), name, selector), dashboardUnit(name), name + " p90"
+	case dto.MetricType_SUMMARY:
+		return fmt.Sprintf("%s (rate(%s_sum%s[$__rate_interval])) / %s (rate(%s_count%s[$__rate_interval]))", by, name, selector, by, name, selector), dashboardUnit(name), name + " average"
+	default:
+		if strings.HasSuffix(name, "_timestamp_seconds") {
+			return fmt.Sprintf("time() - %s (%s%s)", strings.Replace(by, "sum", "max", 1), name, selector), "s", name + " age"
+		}
+		return fmt.Sprintf("%s (%s%s)", by, name, selector), dashboardUnit(name), name
+	}
+}
+
+func dashboardUnit(name string) string {
+	switch {
+	case strings.Contains(name, "bytes"):
+		return "bytes"
+	case strings.Contains(name, "seconds"):
+		return "s"
+	default:
+		return "short"
+	}
+}
+
+// varyingLabels returns the sorted labels with more than one value in family.
+func varyingLabels(family *dto.MetricFamily) []string {
+	values := map[string]map[string]bool{}
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if dashboardSkipLabels[label.GetName()] {
+				continue
+			}
+			if values[label.GetName()] == nil {
+				values[label.GetName()] = map[string]bool{}
+			}
+			values[label.GetName()][label.GetValue()] = true
+		}
+	}
+	var labels []string
+	for name, seen := range values {
+		if len(seen) > 1 {
+			labels = append(labels, name)
+		}
+	}
+	slices.Sort(labels)
+	return labels
+}
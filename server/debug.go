@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// ServeDebug exposes pprof and expvar on their own listener, so profiling is never reachable via the metrics port.
+func ServeDebug(listenAddress string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	slog.Info("start debug listener!", "address", listenAddress)
+	slog.Error("debug listener failed", "err", http.ListenAndServe(listenAddress, mux))
+	os.Exit(1)
+}
+
+// StatusHandler serves the last status the metrics were derived from, as tailscale status -json does.
+// Before the first fetch it asks provider. With redact host names, DNS names, IPs, keys and user names are replaced.
+func (health *Health) StatusHandler(provider tailscaleclient.StatusProvider, redact bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := health.LastStatus()
+		if status == nil {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+			defer cancel()
+			var err error
+			if status, err = provider.Status(ctx); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if redact {
+			status = redactStatus(status)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(status)
+	}
+}
+
+const redacted = "REDACTED"
+
+// redactStatus returns a copy of status without identifying values. Slices keep their length.
+func redactStatus(status *tailscaleclient.Status) *tailscaleclient.Status {
+	copied := *status
+	copied.AuthURL = redactString(copied.AuthURL)
+	copied.TailscaleIPs = redactStrings(copied.TailscaleIPs)
+	copied.MagicDNSSuffix = redactString(copied.MagicDNSSuffix)
+	copied.CurrentTailnet.Name = redactString(copied.CurrentTailnet.Name)
+	copied.CurrentTailnet.MagicDNSSuffix = redactString(copied.CurrentTailnet.MagicDNSSuffix)
+	self := &copied.Self
+	self.PublicKey, self.HostName, self.DNSName, self.CurAddr = redactString(self.PublicKey), redactString(self.HostName), redactString(self.DNSName), redactString(self.CurAddr)
+	self.TailscaleIPs, self.AllowedIPs, self.Addrs, self.PeerAPIURL = redactStrings(self.TailscaleIPs), redactStrings(self.AllowedIPs), redactStrings(self.Addrs), redactStrings(self.PeerAPIURL)
+	copied.Peer = make(map[string]tailscaleclient.Peer, len(status.Peer))
+	for i, nodeKey := range slices.Sorted(maps.Keys(status.Peer)) {
+		peer := status.Peer[nodeKey]
+		peer.PublicKey, peer.HostName, peer.DNSName, peer.CurAddr = redactString(peer.PublicKey), redactString(peer.HostName), redactString(peer.DNSName), redactString(peer.CurAddr)
+		peer.TailscaleIPs, peer.AllowedIPs, peer.Addrs, peer.PeerAPIURL = redactStrings(peer.TailscaleIPs), redactStrings(peer.AllowedIPs), redactStrings(peer.Addrs), redactStrings(peer.PeerAPIURL)
+		copied.Peer[fmt.Sprintf("%s-%d", redacted, i)] = peer
+	}
+	copied.User = maps.Clone(status.User)
+	for id, user := range copied.User {
+		user.LoginName, user.DisplayName, user.ProfilePicURL = redactString(user.LoginName), redactString(user.DisplayName), redactString(user.ProfilePicURL)
+		copied.User[id] = user
+	}
+	return &copied
+}
+
+func redactString(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
+
+func redactStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	result := make([]string, len(values))
+	for i := range values {
+		result[i] = redacted
+	}
+	return result
+}
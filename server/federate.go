@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+var FederateUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "federate_up",
+	Help: "Whether the last /federate scrape of the peer exporter succeeded.",
+}, []string{"peer_id", "peer_name", "peer_ip"})
+
+var FederateScrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "federate_scrape_duration_seconds",
+	Help: "Duration of the last /federate scrape of the peer exporter.",
+}, []string{"peer_id", "peer_name", "peer_ip"})
+
+// federateAccept prefers protobuf, it keeps the metric types exactly
+const federateAccept = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3"
+
+// FederationGatherer scrapes the exporters of the selected peers over the tailnet on every Gather
+// and merges their series, labeled with peer_id, peer_name and peer_ip.
+// Labels of the peer series with these names are kept as exported_<name>, like honor_labels: false.
+type FederationGatherer struct {
+	Provider   tailscaleclient.StatusProvider
+	Filter     SDFilter
+	Port       string
+	Path       string
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+type federatedPeer struct {
+	labels   []*dto.LabelPair
+	families []*dto.MetricFamily
+}
+
+func (gatherer *FederationGatherer) Gather() ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gatherer.Timeout)
+	defer cancel()
+	status, err := gatherer.Provider.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups := slices.DeleteFunc(sdTargets(status, gatherer.Port, gatherer.Filter), func(group SDTargetGroup) bool {
+		return group.Labels["__meta_tailscale_self"] == "true"
+	})
+	peers := make([]federatedPeer, len(groups))
+	FederateUp.Reset()
+	FederateScrapeDuration.Reset()
+	wg := sync.WaitGroup{}
+	for i, group := range groups {
+		wg.Go(func() {
+			id, name, ip := group.Labels["__meta_tailscale_node_id"], group.Labels["__meta_tailscale_hostname"], group.Labels["__meta_tailscale_ip"]
+			start := time.Now()
+			families, err := gatherer.fetch(ctx, group.Targets[0])
+			FederateScrapeDuration.WithLabelValues(id, name, ip).Set(time.Since(start).Seconds())
+			if err != nil {
+				slog.Warn("federate scrape failed", "peer", name, "target", group.Targets[0], "err", err)
+				FederateUp.WithLabelValues(id, name, ip).Set(0)
+				return
+			}
+			FederateUp.WithLabelValues(id, name, ip).Set(1)
+			peers[i] = federatedPeer{
+				labels: []*dto.LabelPair{
+					{Name: new("peer_id"), Value: new(id)},
+					{Name: new("peer_name"), Value: new(name)},
+					{Name: new("peer_ip"), Value: new(ip)},
+				},
+				families: families,
+			}
+		})
+	}
+	wg.Wait()
+	return mergeFederated(peers)
+}
+
+func (gatherer *FederationGatherer) fetch(ctx context.Context, target string) ([]*dto.MetricFamily, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target+gatherer.Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error on create request: %w", err)
+	}
+	request.Header.Set("Accept", federateAccept)
+	response, err := gatherer.HTTPClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error on request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	decoder := expfmt.NewDecoder(response.Body, expfmt.ResponseFormat(response.Header))
+	var families []*dto.MetricFamily
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if errors.Is(err, io.EOF) {
+				return families, nil
+			}
+			return nil, fmt.Errorf("error on decode metrics: %w", err)
+		}
+		families = append(families, family)
+	}
+}
+
+// mergeFederated adds the peer labels and merges families of the same name.
+// A family with another type than the one first seen is dropped, the registry would reject it.
+func mergeFederated(peers []federatedPeer) ([]*dto.MetricFamily, error) {
+	merged := map[string]*dto.MetricFamily{}
+	var errs []error
+	for _, peer := range peers {
+		for _, family := range peer.families {
+			name := family.GetName()
+			target, ok := merged[name]
+			if !ok {
+				target = &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				merged[name] = target
+			}
+			if target.GetType() != family.GetType() {
+				errs = append(errs, fmt.Errorf("%s of peer %s is a %s, other peers have a %s", name, peer.labels[1].GetValue(), family.GetType(), target.GetType()))
+				continue
+			}
+			for _, metric := range family.Metric {
+				metric.Label = withPeerLabels(metric.Label, peer.labels)
+				target.Metric = append(target.Metric, metric)
+			}
+		}
+	}
+	families := slices.Collect(maps.Values(merged))
+	slices.SortFunc(families, func(a, b *dto.MetricFamily) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return families, errors.Join(errs...)
+}
+
+func withPeerLabels(labels []*dto.LabelPair, peerLabels []*dto.LabelPair) []*dto.LabelPair {
+	for _, label := range labels {
+		if slices.ContainsFunc(peerLabels, func(peerLabel *dto.LabelPair) bool { return peerLabel.GetName() == label.GetName() }) {
+			label.Name = new("exported_" + label.GetName())
+		}
+	}
+	for _, peerLabel := range peerLabels {
+		// a copy per series, label privacy rewrites the pairs in place
+		labels = append(labels, &dto.LabelPair{Name: peerLabel.Name, Value: peerLabel.Value})
+	}
+	slices.SortFunc(labels, func(a, b *dto.LabelPair) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return labels
+}
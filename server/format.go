@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"github.com/prometheus/common/expfmt"
+	"net/http"
+)
+
+// formats are the exposition formats selectable with ?format=.
+var formats = map[string]expfmt.Format{
+	"text":        expfmt.NewFormat(expfmt.TypeTextPlain),
+	"openmetrics": expfmt.NewFormat(expfmt.TypeOpenMetrics),
+	"protobuf":    expfmt.NewFormat(expfmt.TypeProtoDelim),
+}
+
+// FormatHandler lets ?format=text, openmetrics or protobuf replace the Accept header of the request,
+// for agents which cannot set headers. next negotiates the format as for any other request,
+// OpenMetrics falls back to text unless it is enabled.
+func FormatHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		format, ok := formats[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown format %q, known are text, openmetrics and protobuf", name), http.StatusBadRequest)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Accept", string(format))
+		next.ServeHTTP(w, r)
+	})
+}
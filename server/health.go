@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// Health remembers the outcome of the last status fetch for readiness checks and /debug/status.
+type Health struct {
+	// StartupDeadline keeps readyz failing until the first successful status fetch, at most until then
+	StartupDeadline time.Time
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastErr     error
+	lastStatus  *tailscaleclient.Status
+}
+
+// Track wraps provider to record every outcome.
+func (health *Health) Track(provider tailscaleclient.StatusProvider) tailscaleclient.StatusProvider {
+	return tailscaleclient.StatusFunc(func(ctx context.Context) (*tailscaleclient.Status, error) {
+		status, err := provider.Status(ctx)
+		health.mu.Lock()
+		health.lastErr = err
+		if err == nil {
+			health.lastSuccess = time.Now()
+			health.lastStatus = status
+		}
+		health.mu.Unlock()
+		return status, err
+	})
+}
+
+func (health *Health) LastSuccess() time.Time {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.lastSuccess
+}
+
+func (health *Health) LastErr() error {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.lastErr
+}
+
+// LastStatus returns the last successfully fetched status, nil before the first one.
+func (health *Health) LastStatus() *tailscaleclient.Status {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.lastStatus
+}
+
+func HealthzHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// WaitFirstStatus fetches the status through provider, tracked by health, until it succeeds or StartupDeadline passes.
+func (health *Health) WaitFirstStatus(ctx context.Context, provider tailscaleclient.StatusProvider, timeout time.Duration) error {
+	ctx, cancel := context.WithDeadline(ctx, health.StartupDeadline)
+	defer cancel()
+	for {
+		fetchCtx, fetchCancel := context.WithTimeout(ctx, timeout)
+		_, err := provider.Status(fetchCtx)
+		fetchCancel()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no successful status before the startup deadline: %w", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// ReadyzHandler is ready while the last status fetch succeeded within maxAge.
+// Otherwise it checks tailscaled right away using check. Before StartupDeadline, only a first successful fetch makes it ready.
+func (health *Health) ReadyzHandler(maxAge time.Duration, check tailscaleclient.StatusProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if health.LastSuccess().IsZero() && time.Now().Before(health.StartupDeadline) {
+			http.Error(w, "not ready: waiting for the first successful status", http.StatusServiceUnavailable)
+			return
+		}
+		if time.Since(health.LastSuccess()) <= maxAge {
+			w.Write([]byte("ok\n"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+		defer cancel()
+		if _, err := check.Status(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("not ready: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
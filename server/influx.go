@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+// InfluxHandler serves the gathered metrics as InfluxDB line protocol, shaped like the
+// Telegraf prometheus input: counter/gauge/value fields, and sum, count and bucket/quantile fields.
+func InfluxHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			// Gather returns what it could collect along with the error
+			slog.Error("error on gather metrics", "err", err)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := writeInflux(w, families, time.Now()); err != nil {
+			slog.Error("error on write influx", "err", err)
+		}
+	})
+}
+
+func writeInflux(w io.Writer, families []*dto.MetricFamily, now time.Time) error {
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			fields := map[string]float64{}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				fields["counter"] = metric.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				fields["gauge"] = metric.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				fields["value"] = metric.GetUntyped().GetValue()
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					fields[strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)] = quantile.GetValue()
+				}
+				fields["sum"] = summary.GetSampleSum()
+				fields["count"] = float64(summary.GetSampleCount())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					fields[strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)] = float64(bucket.GetCumulativeCount())
+				}
+				fields["+Inf"] = float64(histogram.GetSampleCount())
+				fields["sum"] = histogram.GetSampleSum()
+				fields["count"] = float64(histogram.GetSampleCount())
+			}
+			line := influxLine(family.GetName(), metric.GetLabel(), fields)
+			if line == "" {
+				continue
+			}
+			timestamp := now.UnixNano()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs() * int64(time.Millisecond)
+			}
+			if _, err := fmt.Fprintf(w, "%s %d\n", line, timestamp); err != nil {
+				return fmt.Errorf("error on write influx line: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// influxLine returns the line without timestamp, or "" when no field has a finite value.
+// Line protocol can't represent NaN and Inf.
+func influxLine(measurement string, labels []*dto.LabelPair, fields map[string]float64) string {
+	line := strings.Builder{}
+	line.WriteString(influxMeasurementEscaper.Replace(measurement))
+	for _, label := range labels {
+		if label.GetValue() == "" {
+			// empty tag values are not allowed
+			continue
+		}
+		line.WriteString("," + influxEscaper.Replace(label.GetName()) + "=" + influxEscaper.Replace(label.GetValue()))
+	}
+	separator := " "
+	written := false
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		value := fields[name]
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		line.WriteString(separator + influxEscaper.Replace(name) + "=" + strconv.FormatFloat(value, 'g', -1, 64))
+		separator = ","
+		written = true
+	}
+	if !written {
+		return ""
+	}
+	return line.String()
+}
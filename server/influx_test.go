@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWriteInflux(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rx := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "peer_rx_bytes_total"}, []string{"peer_name", "os"})
+	online := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peer_online"}, []string{"peer_name"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ping_latency_seconds", Buckets: []float64{0.1}})
+	registry.MustRegister(rx, online, latency)
+	// tags and measurements escape commas, equal signs and spaces, empty tags are left out
+	rx.WithLabelValues("my host,1", "").Add(42)
+	online.WithLabelValues("a=b").Set(1)
+	// NaN can not be written, a line without fields is left out
+	online.WithLabelValues("nan").Set(math.NaN())
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("error on gather: %v", err)
+	}
+
+	got := bytes.Buffer{}
+	if err := writeInflux(&got, families, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("error on write influx: %v", err)
+	}
+	want := `peer_online,peer_name=a\=b gauge=1 1700000000000000000
+peer_rx_bytes_total,peer_name=my\ host\,1 counter=42 1700000000000000000
+ping_latency_seconds +Inf=2,0.1=1,count=2,sum=0.55 1700000000000000000
+`
+	if got.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", got.String(), want)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// jsonSelfLabels identify a monitored node, series having them but no peer_ label describe the node itself.
+var jsonSelfLabels = []string{"id", "name", "given_name", "ip", "instance_name"}
+
+type JSONSeries struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// JSONNode is a node and its series, without the labels identifying it.
+type JSONNode struct {
+	Labels  map[string]string `json:"labels"`
+	Metrics []JSONSeries      `json:"metrics"`
+}
+
+type JSONMetrics struct {
+	Self       []*JSONNode  `json:"self"`
+	Peers      []*JSONNode  `json:"peers"`
+	Aggregates []JSONSeries `json:"aggregates"`
+}
+
+// JSONMetricsHandler serves the gathered metrics as JSON grouped by node, for consumers that don't parse
+// the Prometheus exposition format. Histograms and summaries are reduced to their _sum and _count.
+func JSONMetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			// Gather returns what it could collect along with the error
+			slog.Error("error on gather metrics", "err", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(jsonMetrics(families)); err != nil {
+			slog.Error("error on write json metrics", "err", err)
+		}
+	})
+}
+
+func jsonMetrics(families []*dto.MetricFamily) JSONMetrics {
+	result := JSONMetrics{Self: []*JSONNode{}, Peers: []*JSONNode{}, Aggregates: []JSONSeries{}}
+	nodes := map[string]*JSONNode{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			identity := map[string]string{}
+			isPeer := false
+			for name, value := range labels {
+				if strings.HasPrefix(name, "peer_") || slices.Contains(jsonSelfLabels, name) {
+					identity[name] = value
+					delete(labels, name)
+					isPeer = isPeer || strings.HasPrefix(name, "peer_")
+				}
+			}
+			if len(labels) == 0 {
+				labels = nil
+			}
+			series := jsonSeries(family, metric, labels)
+			if len(identity) == 0 || (!isPeer && identity["id"] == "") {
+				result.Aggregates = append(result.Aggregates, series...)
+				continue
+			}
+			key := jsonNodeKey(identity)
+			node, ok := nodes[key]
+			if !ok {
+				node = &JSONNode{Labels: identity}
+				nodes[key] = node
+				if isPeer {
+					result.Peers = append(result.Peers, node)
+				} else {
+					result.Self = append(result.Self, node)
+				}
+			}
+			node.Metrics = append(node.Metrics, series...)
+		}
+	}
+	return result
+}
+
+func jsonSeries(family *dto.MetricFamily, metric *dto.Metric, labels map[string]string) []JSONSeries {
+	name := family.GetName()
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return []JSONSeries{{Name: name, Labels: labels, Value: metric.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []JSONSeries{{Name: name, Labels: labels, Value: metric.GetGauge().GetValue()}}
+	case dto.MetricType_UNTYPED:
+		return []JSONSeries{{Name: name, Labels: labels, Value: metric.GetUntyped().GetValue()}}
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		return []JSONSeries{{Name: name + "_sum", Labels: labels, Value: summary.GetSampleSum()}, {Name: name + "_count", Labels: labels, Value: float64(summary.GetSampleCount())}}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		return []JSONSeries{{Name: name + "_sum", Labels: labels, Value: histogram.GetSampleSum()}, {Name: name + "_count", Labels: labels, Value: float64(histogram.GetSampleCount())}}
+	}
+	return nil
+}
+
+func jsonNodeKey(identity map[string]string) string {
+	var key strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(identity)) {
+		key.WriteString(name + "=" + identity[name] + "\xff")
+	}
+	return key.String()
+}
@@ -0,0 +1,299 @@
+// Package server exposes the gathered metrics: HTTP listeners, access control, service discovery,
+// federation and the push outputs.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// Version is reported by the push outputs, main sets it from the build.
+var Version = "dev"
+
+type WebConfig struct {
+	ConfigFile      string        `yaml:"config_file"`
+	ReadyMaxAge     time.Duration `yaml:"ready_max_age"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	ListenIPv4      bool          `yaml:"listen_ipv4"`
+	ListenIPv6      bool          `yaml:"listen_ipv6"`
+	ListenLocalhost bool          `yaml:"listen_localhost"`
+	ListenUnix      string        `yaml:"listen_unix"`
+	EnableInflux    bool          `yaml:"enable_influx"`
+	// EnableOpenMetrics negotiates OpenMetrics with _created samples on /metrics
+	EnableOpenMetrics bool          `yaml:"enable_openmetrics"`
+	EnableSD          bool          `yaml:"enable_sd"`
+	EnableDashboard   bool          `yaml:"enable_dashboard"`
+	EnableJSONAPI     bool          `yaml:"enable_json_api"`
+	SDPort            string        `yaml:"sd_port"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// TLS without web.config.file, TLSClientCAFile enables mTLS
+	TLSCertFile     string `yaml:"tls_cert_file"`
+	TLSKeyFile      string `yaml:"tls_key_file"`
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	// MaxConnections and MaxRequestsInFlight of 0 are unlimited
+	MaxConnections      int `yaml:"max_connections"`
+	MaxRequestsInFlight int `yaml:"max_requests_in_flight"`
+	// EnableConfigAPI serves /-/config, ConfigAPIPersist writes its changes to the config file
+	EnableConfigAPI  bool `yaml:"enable_config_api"`
+	ConfigAPIPersist bool `yaml:"config_api_persist"`
+	// StartupTimeout keeps /readyz failing until the first successful status, StartupDelayServe also the whole HTTP server
+	StartupTimeout    time.Duration `yaml:"startup_timeout"`
+	StartupDelayServe bool          `yaml:"startup_delay_serve"`
+	// RateLimitRequests per RateLimitInterval and client address on /metrics and /probe, 0 is unlimited
+	RateLimitRequests int           `yaml:"rate_limit_requests"`
+	RateLimitInterval time.Duration `yaml:"rate_limit_interval"`
+}
+
+// ListenFamilies returns the Tailscale address families to listen on.
+func (web WebConfig) ListenFamilies() []string {
+	var families []string
+	if web.ListenIPv4 {
+		families = append(families, "ipv4")
+	}
+	if web.ListenIPv6 {
+		families = append(families, "ipv6")
+	}
+	return families
+}
+
+// NewWebListener opens the listeners selected by cfg, or takes over the sockets passed by
+// systemd socket activation instead. It returns the Tailscale families and IPs listened on.
+func NewWebListener(cfg WebConfig, provider tailscaleclient.StatusProvider, timeout time.Duration) (*RebindableListener, []string, map[string]string, error) {
+	rebindable := NewRebindableListener()
+	inherited, err := systemdListeners()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(inherited) > 0 {
+		for i, listener := range inherited {
+			rebindable.Rebind(fmt.Sprintf("systemd-%d", i), listener)
+		}
+		return rebindable, nil, map[string]string{}, nil
+	}
+
+	families := cfg.ListenFamilies()
+	ips := map[string]string{}
+	if len(families) > 0 {
+		ips, err = getListenAddrs(provider, families, timeout)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, family := range families {
+		ipListener, err := net.Listen("tcp", net.JoinHostPort(ips[family], "9995"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rebindable.Rebind(family, ipListener)
+	}
+	if cfg.ListenLocalhost {
+		localListener, err := net.Listen("tcp", "127.0.0.1:9995")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rebindable.Rebind("localhost", localListener)
+	}
+	if cfg.ListenUnix != "" {
+		unixListener, err := listenUnix(cfg.ListenUnix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rebindable.Rebind("unix", unixListener)
+	}
+	return rebindable, families, ips, nil
+}
+
+// listenUnix listens on a unix socket at path, replacing a stale socket left by a previous run.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error on remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error on listen unix socket: %w", err)
+	}
+	return listener, nil
+}
+
+// RebindableListener hands connections of several named listeners to one http.Server.
+// Rebind adds or swaps a listener without stopping the server.
+type RebindableListener struct {
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	names     []string
+	listeners map[string]net.Listener
+}
+
+func NewRebindableListener() *RebindableListener {
+	return &RebindableListener{
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		done:      make(chan struct{}),
+		listeners: map[string]net.Listener{},
+	}
+}
+
+// Rebind starts accepting on listener and closes the previous listener of the same name.
+func (rebindable *RebindableListener) Rebind(name string, listener net.Listener) {
+	rebindable.mu.Lock()
+	previous, ok := rebindable.listeners[name]
+	if !ok {
+		rebindable.names = append(rebindable.names, name)
+	}
+	rebindable.listeners[name] = listener
+	rebindable.mu.Unlock()
+	go rebindable.acceptLoop(listener)
+	if ok {
+		previous.Close()
+	}
+}
+
+func (rebindable *RebindableListener) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// closed by Rebind or Close
+				return
+			}
+			select {
+			case rebindable.errs <- err:
+			case <-rebindable.done:
+				return
+			}
+			if temporary, ok := err.(interface{ Temporary() bool }); ok && temporary.Temporary() {
+				continue
+			}
+			return
+		}
+		select {
+		case rebindable.conns <- conn:
+		case <-rebindable.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (rebindable *RebindableListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-rebindable.conns:
+		return conn, nil
+	case err := <-rebindable.errs:
+		return nil, err
+	case <-rebindable.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (rebindable *RebindableListener) Close() error {
+	rebindable.closeOnce.Do(func() { close(rebindable.done) })
+	rebindable.mu.Lock()
+	defer rebindable.mu.Unlock()
+	var errs []error
+	for _, name := range rebindable.names {
+		errs = append(errs, rebindable.listeners[name].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Addr returns the address of the first added listener.
+func (rebindable *RebindableListener) Addr() net.Addr {
+	rebindable.mu.Lock()
+	defer rebindable.mu.Unlock()
+	return rebindable.listeners[rebindable.names[0]].Addr()
+}
+
+// Addrs returns the addresses of all listeners.
+func (rebindable *RebindableListener) Addrs() []string {
+	rebindable.mu.Lock()
+	defer rebindable.mu.Unlock()
+	addrs := make([]string, 0, len(rebindable.names))
+	for _, name := range rebindable.names {
+		addrs = append(addrs, rebindable.listeners[name].Addr().String())
+	}
+	return addrs
+}
+
+// getListenAddrs returns the Tailscale IP of every requested family ("ipv4", "ipv6")
+func getListenAddrs(provider tailscaleclient.StatusProvider, families []string, timeout time.Duration) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	status, err := provider.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := map[string]string{}
+	for _, ip := range status.Self.TailscaleIPs {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		family := "ipv4"
+		if addr.Is6() {
+			family = "ipv6"
+		}
+		if _, ok := ips[family]; !ok {
+			ips[family] = ip
+		}
+	}
+	for _, family := range families {
+		if _, ok := ips[family]; !ok {
+			return nil, fmt.Errorf("no tailscale %s address found", family)
+		}
+	}
+
+	return ips, nil
+}
+
+// WatchListenAddrs calls rebind when the Tailscale IP of a listened family changes. Status errors are retried forever.
+func WatchListenAddrs(ctx context.Context, provider tailscaleclient.StatusProvider, families []string, ips map[string]string, timeout time.Duration, rebind func(family string, newIp string) error) {
+	if len(families) == 0 {
+		return
+	}
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 20):
+		}
+		newIps, err := getListenAddrs(provider, families, timeout)
+		if err != nil {
+			// e.g. logged out or an expired key, keep serving on the old addresses so login_required can alert
+			failures++
+			slog.Error("error on update ip, keeping the current listeners", "failures", failures, "err", err)
+			continue
+		}
+		failures = 0
+		for _, family := range families {
+			ip, newIp := ips[family], newIps[family]
+			if newIp == ip {
+				continue
+			}
+			if err := rebind(family, newIp); err != nil {
+				slog.Error("error on rebind", "family", family, "ip", newIp, "err", err)
+				continue
+			}
+			slog.Info("found new ip, listener rebound", "family", family, "was", ip, "now", newIp)
+			ips[family] = newIp
+		}
+	}
+}
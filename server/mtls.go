@@ -0,0 +1,37 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// MTLSConfig serves TLS with certFile and keyFile and, if clientCAFile is set, requires client
+// certificates signed by it. The files are read on every handshake, so rotated certificates apply without restart.
+func MTLSConfig(certFile string, keyFile string, clientCAFile string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("error on load tls cert: %w", err)
+			}
+			config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+			if clientCAFile == "" {
+				return config, nil
+			}
+			content, err := os.ReadFile(clientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("error on read client ca: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("error on parse client ca %s: no PEM certificate", clientCAFile)
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			return config, nil
+		},
+	}
+}
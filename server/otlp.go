@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"time"
+)
+
+type OTLPConfig struct {
+	Endpoint string            `yaml:"endpoint"`
+	Protocol string            `yaml:"protocol"`
+	Interval time.Duration     `yaml:"interval"`
+	Headers  map[string]string `yaml:"headers"`
+}
+
+// StartOTLP pushes everything gathered by gatherer to an OTLP collector on cfg.Interval.
+// The standard OTEL_EXPORTER_OTLP_* environment variables are honored as well.
+// The returned provider must be shut down to flush the last push.
+func StartOTLP(ctx context.Context, cfg OTLPConfig, gatherer prometheus.Gatherer) (*sdkmetric.MeterProvider, error) {
+	var exporter sdkmetric.Exporter
+	var err error
+	switch cfg.Protocol {
+	case "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint), otlpmetricgrpc.WithHeaders(cfg.Headers))
+	case "http/protobuf":
+		exporter, err = otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(cfg.Endpoint), otlpmetrichttp.WithHeaders(cfg.Headers))
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %q", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error on create otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "tailscale-exporter"),
+		attribute.String("service.version", Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error on otlp resource: %w", err)
+	}
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.Interval),
+		sdkmetric.WithProducer(prometheusbridge.NewMetricProducer(prometheusbridge.WithGatherer(gatherer))),
+	)
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res)), nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"tailscale-exporter/collector"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// ProbeHandler serves /probe?target=<peer>&module=ping|tcp in blackbox_exporter style:
+// every request runs a fresh probe and returns only its results.
+func ProbeHandler(cli tailscaleclient.CLI, defaultTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		module := r.URL.Query().Get("module")
+		if module == "" {
+			module = "ping"
+		}
+		timeout := probeTimeout(r, defaultTimeout)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		successGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Displays whether or not the probe was a success.",
+		})
+		durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Returns how long the probe took to complete in seconds.",
+		})
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(successGauge, durationGauge)
+
+		start := time.Now()
+		var err error
+		switch module {
+		case "ping":
+			err = probePing(ctx, cli, target, timeout, registry)
+		case "tcp":
+			err = collector.ProbeTCP(ctx, target)
+		default:
+			http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+			return
+		}
+		durationGauge.Set(time.Since(start).Seconds())
+		if err != nil {
+			slog.Warn("probe failed", "module", module, "target", target, "err", err)
+		} else {
+			successGauge.Set(1)
+		}
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+func probePing(ctx context.Context, cli tailscaleclient.CLI, target string, timeout time.Duration, registry *prometheus.Registry) error {
+	result, err := cli.Ping(ctx, target, timeout)
+	if err != nil {
+		return err
+	}
+	latencyGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tailscale_ping_latency_seconds",
+		Help: "Latency of the tailscale ping.",
+	})
+	directGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_tailscale_ping_direct",
+		Help: "Whether the pong came over a direct connection.",
+	})
+	registry.MustRegister(latencyGauge, directGauge)
+	latencyGauge.Set(result.Latency.Seconds())
+	if result.Path() == "direct" {
+		directGauge.Set(1)
+	}
+	return nil
+}
+
+// probeTimeout uses the scrape timeout announced by Prometheus, leaving a bit for the response.
+func probeTimeout(r *http.Request, defaultTimeout time.Duration) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return defaultTimeout
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 1 {
+		return defaultTimeout
+	}
+	timeout := time.Duration((seconds - 0.5) * float64(time.Second))
+	if timeout < defaultTimeout {
+		return timeout
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type PushgatewayConfig struct {
+	URL      string            `yaml:"gateway_url"`
+	Interval time.Duration     `yaml:"interval"`
+	Job      string            `yaml:"job"`
+	Instance string            `yaml:"instance"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Grouping map[string]string `yaml:"grouping"`
+}
+
+// Pushgateway periodically gathers all metrics and replaces the group of this exporter on a Prometheus Pushgateway,
+// for short-lived or NAT-isolated nodes that can't be scraped.
+type Pushgateway struct {
+	Interval time.Duration
+
+	pusher *push.Pusher
+}
+
+// NewPushgateway groups the metrics by job, instance and cfg.Grouping. An empty instance is not part of the group.
+func NewPushgateway(cfg PushgatewayConfig, gatherer prometheus.Gatherer) *Pushgateway {
+	pusher := push.New(cfg.URL, cfg.Job).Gatherer(gatherer).Client(&http.Client{Timeout: time.Second * 30})
+	if cfg.Instance != "" {
+		pusher = pusher.Grouping("instance", cfg.Instance)
+	}
+	for name, value := range cfg.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	if cfg.Username != "" {
+		pusher = pusher.BasicAuth(cfg.Username, cfg.Password)
+	}
+	return &Pushgateway{Interval: cfg.Interval, pusher: pusher}
+}
+
+func (gateway *Pushgateway) Run(ctx context.Context) {
+	for {
+		if err := gateway.pusher.PushContext(ctx); err != nil {
+			slog.Error("pushgateway push failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(gateway.Interval):
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var RateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "exporter_rate_limited_requests_total",
+	Help: "Number of requests answered with 429 by the per-client rate limit.",
+}, []string{"path"})
+
+// RateLimiter allows each client address at most Requests requests per Interval, in fixed windows.
+// Every scrape may run the tailscale CLI, a constrained device must not be kept busy by a single client.
+type RateLimiter struct {
+	Requests int
+	Interval time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// allow counts a request of source and returns how long to wait if it is over the limit.
+func (limiter *RateLimiter) allow(source string, now time.Time) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.windows == nil {
+		limiter.windows = map[string]*rateWindow{}
+	}
+	window, ok := limiter.windows[source]
+	if !ok || now.Sub(window.start) >= limiter.Interval {
+		// expired windows of other clients go too, so scanning clients cannot grow the map
+		for other, otherWindow := range limiter.windows {
+			if now.Sub(otherWindow.start) >= limiter.Interval {
+				delete(limiter.windows, other)
+			}
+		}
+		window = &rateWindow{start: now}
+		limiter.windows[source] = window
+	}
+	if window.count >= limiter.Requests {
+		return false, window.start.Add(limiter.Interval).Sub(now)
+	}
+	window.count++
+	return true, 0
+}
+
+// Wrap answers requests over the limit of their client with 429. A limiter without Requests or Interval allows everything.
+func (limiter *RateLimiter) Wrap(next http.Handler) http.Handler {
+	if limiter.Requests <= 0 || limiter.Interval <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			// unix socket clients have no address and share one limit
+			source = r.RemoteAddr
+		}
+		if ok, retryAfter := limiter.allow(source, time.Now()); !ok {
+			RateLimitedRequests.WithLabelValues(r.URL.Path).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
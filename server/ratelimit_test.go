@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := &RateLimiter{Requests: 2, Interval: time.Minute}
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		source string
+		at     time.Duration
+		want   bool
+		wait   time.Duration
+	}{
+		{"first", "10.0.0.1", 0, true, 0},
+		{"second", "10.0.0.1", time.Second, true, 0},
+		{"over the limit", "10.0.0.1", 20 * time.Second, false, 40 * time.Second},
+		{"other client", "10.0.0.2", 20 * time.Second, true, 0},
+		{"next window", "10.0.0.1", time.Minute, true, 0},
+	}
+	for _, test := range tests {
+		ok, wait := limiter.allow(test.source, start.Add(test.at))
+		if ok != test.want || wait != test.wait {
+			t.Errorf("%s: got %v and %v, want %v and %v", test.name, ok, wait, test.want, test.wait)
+		}
+	}
+	// the window of 10.0.0.2 expired with the new window of 10.0.0.1
+	limiter.allow("10.0.0.1", start.Add(2*time.Minute))
+	if _, ok := limiter.windows["10.0.0.2"]; ok || len(limiter.windows) != 1 {
+		t.Errorf("got windows %v, want expired ones removed", limiter.windows)
+	}
+}
+
+func TestRateLimiterWrap(t *testing.T) {
+	handler := (&RateLimiter{Requests: 1, Interval: time.Minute}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if w.Code != want {
+			t.Errorf("request %d: got %d, want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("request %d: got Retry-After %q, want 60", i, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type RemoteWriteConfig struct {
+	URL         string            `yaml:"url"`
+	Interval    time.Duration     `yaml:"interval"`
+	Username    string            `yaml:"username"`
+	Password    string            `yaml:"password"`
+	BearerToken string            `yaml:"bearer_token"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// RemoteWriter periodically gathers all metrics and pushes them with Prometheus remote_write 1.0,
+// for nodes that can't be scraped.
+type RemoteWriter struct {
+	Gatherer    prometheus.Gatherer
+	URL         string
+	Interval    time.Duration
+	Username    string
+	Password    string
+	BearerToken string
+	// added to every series, e.g. job and instance
+	Labels map[string]string
+
+	httpClient *http.Client
+}
+
+func NewRemoteWriter(cfg RemoteWriteConfig, gatherer prometheus.Gatherer, labels map[string]string) *RemoteWriter {
+	return &RemoteWriter{
+		Gatherer:    gatherer,
+		URL:         cfg.URL,
+		Interval:    cfg.Interval,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		BearerToken: cfg.BearerToken,
+		Labels:      labels,
+		httpClient:  &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+func (writer *RemoteWriter) Run(ctx context.Context) {
+	for {
+		if err := writer.Push(ctx); err != nil {
+			slog.Error("remote write failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(writer.Interval):
+		}
+	}
+}
+
+func (writer *RemoteWriter) Push(ctx context.Context) error {
+	families, err := writer.Gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		slog.Error("error on gather metrics", "err", err)
+	}
+	body := s2.EncodeSnappy(nil, writer.encode(families, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, writer.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error on remote_write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "tailscale-exporter/"+Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if writer.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+writer.BearerToken)
+	} else if writer.Username != "" {
+		req.SetBasicAuth(writer.Username, writer.Password)
+	}
+	resp, err := writer.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error on remote_write: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("error on remote_write: %s: %s", resp.Status, message)
+	}
+	return nil
+}
+
+// encode builds a prometheus.WriteRequest protobuf message.
+func (writer *RemoteWriter) encode(families []*dto.MetricFamily, now time.Time) []byte {
+	var request []byte
+	addSeries := func(name string, labels map[string]string, value float64, timestampMs int64) {
+		series := map[string]string{}
+		maps.Copy(series, writer.Labels)
+		maps.Copy(series, labels)
+		series["__name__"] = name
+		var timeSeries []byte
+		for _, labelName := range slices.Sorted(maps.Keys(series)) {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, labelName)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, series[labelName])
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestampMs))
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType)
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			timestampMs := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+			withLabel := func(name string, value string) map[string]string {
+				extended := maps.Clone(labels)
+				extended[name] = value
+				return extended
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				addSeries(name, labels, metric.GetCounter().GetValue(), timestampMs)
+			case dto.MetricType_GAUGE:
+				addSeries(name, labels, metric.GetGauge().GetValue(), timestampMs)
+			case dto.MetricType_UNTYPED:
+				addSeries(name, labels, metric.GetUntyped().GetValue(), timestampMs)
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					addSeries(name, withLabel("quantile", strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)), quantile.GetValue(), timestampMs)
+				}
+				addSeries(name+"_sum", labels, summary.GetSampleSum(), timestampMs)
+				addSeries(name+"_count", labels, float64(summary.GetSampleCount()), timestampMs)
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				hasInf := false
+				for _, bucket := range histogram.GetBucket() {
+					hasInf = math.IsInf(bucket.GetUpperBound(), 1)
+					addSeries(name+"_bucket", withLabel("le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)), float64(bucket.GetCumulativeCount()), timestampMs)
+				}
+				if !hasInf {
+					addSeries(name+"_bucket", withLabel("le", "+Inf"), float64(histogram.GetSampleCount()), timestampMs)
+				}
+				addSeries(name+"_sum", labels, histogram.GetSampleSum(), timestampMs)
+				addSeries(name+"_count", labels, float64(histogram.GetSampleCount()), timestampMs)
+			}
+		}
+	}
+	return request
+}
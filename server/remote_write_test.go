@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeWriteRequest returns the samples of a WriteRequest as "name{label=value,...} value@timestamp".
+func decodeWriteRequest(t *testing.T, request []byte) []string {
+	t.Helper()
+	// fields returns the length-delimited fields of message by number, and the fixed64 and varint ones as numbers
+	fields := func(message []byte) (map[protowire.Number][][]byte, map[protowire.Number]uint64) {
+		bytesFields, numbers := map[protowire.Number][][]byte{}, map[protowire.Number]uint64{}
+		for len(message) > 0 {
+			number, fieldType, n := protowire.ConsumeTag(message)
+			if n < 0 {
+				t.Fatalf("error on consume tag: %v", protowire.ParseError(n))
+			}
+			message = message[n:]
+			switch fieldType {
+			case protowire.BytesType:
+				value, n := protowire.ConsumeBytes(message)
+				bytesFields[number] = append(bytesFields[number], value)
+				message = message[n:]
+			case protowire.Fixed64Type:
+				value, n := protowire.ConsumeFixed64(message)
+				numbers[number] = value
+				message = message[n:]
+			case protowire.VarintType:
+				value, n := protowire.ConsumeVarint(message)
+				numbers[number] = value
+				message = message[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", fieldType)
+			}
+		}
+		return bytesFields, numbers
+	}
+	var samples []string
+	parsed, _ := fields(request)
+	for _, timeSeries := range parsed[1] {
+		seriesFields, _ := fields(timeSeries)
+		name, labels := "", []string{}
+		for _, label := range seriesFields[1] {
+			pair, _ := fields(label)
+			if string(pair[1][0]) == "__name__" {
+				name = string(pair[2][0])
+				continue
+			}
+			labels = append(labels, string(pair[1][0])+"="+string(pair[2][0]))
+		}
+		_, sample := fields(seriesFields[2][0])
+		value := strconv.FormatFloat(math.Float64frombits(sample[1]), 'g', -1, 64)
+		samples = append(samples, name+"{"+strings.Join(labels, ",")+"} "+value+"@"+strconv.FormatUint(sample[2], 10))
+	}
+	return samples
+}
+
+func TestRemoteWriterEncode(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rx := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "peer_rx_bytes_total"}, []string{"peer_name"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ping_latency_seconds", Buckets: []float64{0.1}})
+	registry.MustRegister(rx, latency)
+	rx.WithLabelValues("a").Add(42)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("error on gather: %v", err)
+	}
+
+	writer := NewRemoteWriter(RemoteWriteConfig{}, registry, map[string]string{"job": "tailscale"})
+	got := decodeWriteRequest(t, writer.encode(families, time.UnixMilli(1700000000000)))
+	want := []string{
+		"peer_rx_bytes_total{job=tailscale,peer_name=a} 42@1700000000000",
+		"ping_latency_seconds_bucket{job=tailscale,le=0.1} 1@1700000000000",
+		"ping_latency_seconds_bucket{job=tailscale,le=+Inf} 2@1700000000000",
+		"ping_latency_seconds_sum{job=tailscale} 0.55@1700000000000",
+		"ping_latency_seconds_count{job=tailscale} 2@1700000000000",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRemoteWriterPush(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	online := prometheus.NewGauge(prometheus.GaugeOpts{Name: "peers_online"})
+	registry.MustRegister(online)
+	online.Set(3)
+
+	headers := http.Header{}
+	var samples []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := s2.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		samples = decodeWriteRequest(t, body)
+	}))
+	defer receiver.Close()
+
+	writer := NewRemoteWriter(RemoteWriteConfig{URL: receiver.URL, BearerToken: "token"}, registry, nil)
+	if err := writer.Push(context.Background()); err != nil {
+		t.Fatalf("error on push: %v", err)
+	}
+	want := map[string]string{
+		"Authorization":                     "Bearer token",
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+	for name, value := range want {
+		if headers.Get(name) != value {
+			t.Errorf("header %s: got %q, want %q", name, headers.Get(name), value)
+		}
+	}
+	if len(samples) != 1 || !strings.HasPrefix(samples[0], "peers_online{} 3@") {
+		t.Errorf("got samples %v, want peers_online 3", samples)
+	}
+}
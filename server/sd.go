@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// SDTargetGroup is one entry of Prometheus HTTP and file service discovery.
+type SDTargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// SDFilter selects the discovered nodes. Empty tags match every node.
+type SDFilter struct {
+	Tags       []string
+	OnlineOnly bool
+}
+
+func (filter SDFilter) match(tags []string, online bool) bool {
+	if filter.OnlineOnly && !online {
+		return false
+	}
+	if len(filter.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if slices.Contains(filter.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// sdTargets returns one target group per node, this one included, addressed by its first Tailscale IP and port.
+func sdTargets(status *tailscaleclient.Status, port string, filter SDFilter) []SDTargetGroup {
+	groups := []SDTargetGroup{}
+	add := func(id, hostname, dnsName, os string, userID int, ips []string, tags []string, online bool, self bool) {
+		if len(ips) == 0 || !filter.match(tags, online) {
+			return
+		}
+		labels := map[string]string{
+			"__meta_tailscale_node_id":  id,
+			"__meta_tailscale_hostname": hostname,
+			"__meta_tailscale_dns_name": strings.TrimSuffix(dnsName, "."),
+			"__meta_tailscale_os":       os,
+			"__meta_tailscale_user":     status.User[strconv.Itoa(userID)].LoginName,
+			"__meta_tailscale_online":   strconv.FormatBool(online),
+			"__meta_tailscale_self":     strconv.FormatBool(self),
+			"__meta_tailscale_ip":       ips[0],
+		}
+		if len(tags) > 0 {
+			// delimited at both ends, so relabel regexes can match ,tag:x,
+			labels["__meta_tailscale_tags"] = "," + strings.Join(tags, ",") + ","
+		}
+		groups = append(groups, SDTargetGroup{Targets: []string{net.JoinHostPort(ips[0], port)}, Labels: labels})
+	}
+	self := status.Self
+	add(self.ID, self.HostName, self.DNSName, self.OS, self.UserID, self.TailscaleIPs, self.Tags, self.Online, true)
+	for _, peer := range status.Peer {
+		add(peer.ID, peer.HostName, peer.DNSName, peer.OS, peer.UserID, peer.TailscaleIPs, peer.Tags, peer.Online, false)
+	}
+	slices.SortFunc(groups, func(a, b SDTargetGroup) int { return strings.Compare(a.Targets[0], b.Targets[0]) })
+	return groups
+}
+
+// SDHandler serves Prometheus HTTP service discovery of the tailnet nodes.
+// Query parameters: port (defaults to defaultPort), tag (repeatable) and online=true.
+func SDHandler(provider tailscaleclient.StatusProvider, defaultPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		port := defaultPort
+		if query.Has("port") {
+			port = query.Get("port")
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			http.Error(w, "invalid port", http.StatusBadRequest)
+			return
+		}
+		status, err := provider.Status(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		filter := SDFilter{Tags: query["tag"], OnlineOnly: query.Get("online") == "true"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sdTargets(status, port, filter))
+	}
+}
+
+// FileSDWriter periodically writes the tailnet nodes to Path for Prometheus file_sd_configs,
+// as YAML if Path ends in .yml or .yaml, as JSON otherwise.
+type FileSDWriter struct {
+	Provider tailscaleclient.StatusProvider
+	Path     string
+	Port     string
+	Filter   SDFilter
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (writer *FileSDWriter) Run(ctx context.Context) {
+	for {
+		if err := writer.Write(ctx); err != nil {
+			slog.Error("error on write file_sd", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(writer.Interval):
+		}
+	}
+}
+
+// Write replaces Path atomically when the targets changed.
+func (writer *FileSDWriter) Write(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, writer.Timeout)
+	defer cancel()
+	status, err := writer.Provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	groups := sdTargets(status, writer.Port, writer.Filter)
+	var content []byte
+	if ext := filepath.Ext(writer.Path); ext == ".yml" || ext == ".yaml" {
+		content, err = yaml.Marshal(groups)
+	} else {
+		content, err = json.MarshalIndent(groups, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("error on marshal file_sd: %w", err)
+	}
+	if previous, err := os.ReadFile(writer.Path); err == nil && bytes.Equal(previous, content) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(writer.Path), "."+filepath.Base(writer.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("error on create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error on write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error on close temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("error on chmod temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), writer.Path); err != nil {
+		return fmt.Errorf("error on rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"tailscale-exporter/tailscaleclient"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"time"
+)
+
+type ServeConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Port    uint16 `yaml:"port"`
+	Funnel  bool   `yaml:"funnel"`
+}
+
+// ServeMetrics registers the metrics endpoint listening on listenAddr with `tailscale serve`
+func ServeMetrics(ctx context.Context, cfg ServeConfig, timeout time.Duration, localClient *local.Client, provider tailscaleclient.StatusProvider, listenAddr string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	status, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return registerServe(ctx, localClient, status.Self.DNSName, cfg.Port, "http://"+listenAddr+"/metrics", cfg.Funnel)
+}
+
+// registerServe mounts target at /metrics of `tailscale serve` on the node's MagicDNS name,
+// so tailscaled terminates HTTPS for us. Existing serve handlers are kept.
+func registerServe(ctx context.Context, localClient *local.Client, dnsName string, port uint16, target string, funnel bool) error {
+	host := strings.TrimSuffix(dnsName, ".")
+	if host == "" {
+		return fmt.Errorf("node has no MagicDNS name")
+	}
+	serveConfig, err := localClient.GetServeConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get serve config: %w", err)
+	}
+	if serveConfig == nil {
+		serveConfig = &ipn.ServeConfig{}
+	}
+	serveConfig.SetWebHandler(&ipn.HTTPHandler{Proxy: target}, host, port, "/metrics", true, "")
+	// turning Funnel off would also close it for the operator's handlers on this port
+	if funnel {
+		serveConfig.SetFunnel(host, port, true)
+	}
+	if err := localClient.SetServeConfig(ctx, serveConfig); err != nil {
+		return fmt.Errorf("error on set serve config: %w", err)
+	}
+	slog.Info("serving metrics with tailscale serve", "url", fmt.Sprintf("https://%s:%d/metrics", host, port), "funnel", funnel)
+	return nil
+}
+
+// UnserveMetrics removes the /metrics mount of ServeMetrics, which would proxy to a closed port after exit.
+// Funnel is turned off with it if it was enabled for the exporter and no handlers remain on the port.
+func UnserveMetrics(ctx context.Context, cfg ServeConfig, timeout time.Duration, localClient *local.Client, provider tailscaleclient.StatusProvider) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	status, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(status.Self.DNSName, ".")
+	serveConfig, err := localClient.GetServeConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get serve config: %w", err)
+	}
+	if !serveConfig.WebHandlerExists("", ipn.HostPort(net.JoinHostPort(host, strconv.Itoa(int(cfg.Port)))), "/metrics") {
+		return nil
+	}
+	serveConfig.RemoveWebHandler(host, cfg.Port, []string{"/metrics"}, cfg.Funnel)
+	if err := localClient.SetServeConfig(ctx, serveConfig); err != nil {
+		return fmt.Errorf("error on set serve config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdPacketSize keeps datagrams below the usual MTU.
+const statsdPacketSize = 1432
+
+type StatsDConfig struct {
+	Address  string            `yaml:"address"`
+	Interval time.Duration     `yaml:"interval"`
+	Format   string            `yaml:"format"`
+	Tags     map[string]string `yaml:"tags"`
+}
+
+// StatsDEmitter periodically gathers all metrics and sends counters and gauges to a StatsD server over UDP.
+// Counters are sent as the increase since the previous send, histograms and summaries are not sent.
+// The dogstatsd format sends labels as tags, the statsd format has no tags and appends the label values to the name.
+type StatsDEmitter struct {
+	Gatherer prometheus.Gatherer
+	Address  string
+	Interval time.Duration
+	Format   string
+	// added to every metric as tags
+	Tags map[string]string
+
+	counters map[string]float64
+}
+
+func NewStatsDEmitter(cfg StatsDConfig, gatherer prometheus.Gatherer) *StatsDEmitter {
+	return &StatsDEmitter{
+		Gatherer: gatherer,
+		Address:  cfg.Address,
+		Interval: cfg.Interval,
+		Format:   cfg.Format,
+		Tags:     cfg.Tags,
+		counters: map[string]float64{},
+	}
+}
+
+func (emitter *StatsDEmitter) Run(ctx context.Context) {
+	for {
+		if err := emitter.Send(); err != nil {
+			slog.Error("statsd send failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(emitter.Interval):
+		}
+	}
+}
+
+func (emitter *StatsDEmitter) Send() error {
+	families, err := emitter.Gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		slog.Error("error on gather metrics", "err", err)
+	}
+	// the address is resolved on every send, so a restarted agent with a new IP is found
+	conn, err := net.Dial("udp", emitter.Address)
+	if err != nil {
+		return fmt.Errorf("error on statsd dial: %w", err)
+	}
+	defer conn.Close()
+	var packet []byte
+	for _, line := range emitter.lines(families) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdPacketSize {
+			if _, err := conn.Write(packet); err != nil {
+				return fmt.Errorf("error on statsd write: %w", err)
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := conn.Write(packet); err != nil {
+			return fmt.Errorf("error on statsd write: %w", err)
+		}
+	}
+	return nil
+}
+
+func (emitter *StatsDEmitter) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	seen := map[string]bool{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := maps.Clone(emitter.Tags)
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			for _, pair := range metric.GetLabel() {
+				// an empty label is no label for Prometheus
+				if pair.GetValue() != "" {
+					labels[pair.GetName()] = pair.GetValue()
+				}
+			}
+			name, tags := emitter.nameAndTags(family.GetName(), labels)
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				key := name + "|" + tags
+				seen[key] = true
+				value := metric.GetCounter().GetValue()
+				previous, ok := emitter.counters[key]
+				emitter.counters[key] = value
+				if !ok {
+					continue
+				}
+				increase := value - previous
+				if increase < 0 {
+					// the counter was reset
+					increase = value
+				}
+				lines = append(lines, name+":"+formatStatsD(increase)+"|c"+tags)
+			case dto.MetricType_GAUGE:
+				lines = append(lines, name+":"+formatStatsD(metric.GetGauge().GetValue())+"|g"+tags)
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, name+":"+formatStatsD(metric.GetUntyped().GetValue())+"|g"+tags)
+			}
+		}
+	}
+	// forget vanished series, e.g. of removed peers
+	maps.DeleteFunc(emitter.counters, func(key string, _ float64) bool { return !seen[key] })
+	return lines
+}
+
+// nameAndTags returns the StatsD name and the tag suffix of a series in the configured format.
+func (emitter *StatsDEmitter) nameAndTags(name string, labels map[string]string) (string, string) {
+	names := slices.Sorted(maps.Keys(labels))
+	if emitter.Format == "statsd" {
+		for _, labelName := range names {
+			name += "." + statsDSanitizer.Replace(labels[labelName])
+		}
+		return name, ""
+	}
+	tags := make([]string, 0, len(names))
+	for _, labelName := range names {
+		tags = append(tags, labelName+":"+dogStatsDSanitizer.Replace(labels[labelName]))
+	}
+	if len(tags) == 0 {
+		return name, ""
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+var (
+	statsDSanitizer    = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", "\n", "_")
+	dogStatsDSanitizer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+)
+
+func formatStatsD(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
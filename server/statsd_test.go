@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"slices"
+	"testing"
+)
+
+func TestStatsDEmitterLines(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	rx := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "peer_rx_bytes_total"}, []string{"peer_name", "os"})
+	online := prometheus.NewGauge(prometheus.GaugeOpts{Name: "peers_online"})
+	registry.MustRegister(rx, online)
+	online.Set(3)
+	lines := func(emitter *StatsDEmitter) []string {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("error on gather: %v", err)
+		}
+		return emitter.lines(families)
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		update func()
+		want   []string
+	}{
+		// the first send only remembers the counters
+		{"first", "dogstatsd", func() { rx.WithLabelValues("a|b", "").Add(100) }, []string{"peers_online:3|g|#env:prod"}},
+		{"increase", "dogstatsd", func() { rx.WithLabelValues("a|b", "").Add(50) }, []string{"peer_rx_bytes_total:50|c|#env:prod,peer_name:a_b", "peers_online:3|g|#env:prod"}},
+		{"reset", "dogstatsd", func() { rx.Reset(); rx.WithLabelValues("a|b", "").Add(10) }, []string{"peer_rx_bytes_total:10|c|#env:prod,peer_name:a_b", "peers_online:3|g|#env:prod"}},
+		{"first statsd", "statsd", func() {}, []string{"peers_online.prod:3|g"}},
+		{"increase statsd", "statsd", func() { rx.WithLabelValues("a|b", "").Add(5) }, []string{"peer_rx_bytes_total.prod.a_b:5|c", "peers_online.prod:3|g"}},
+	}
+	emitters := map[string]*StatsDEmitter{}
+	for _, test := range tests {
+		emitter, ok := emitters[test.format]
+		if !ok {
+			emitter = NewStatsDEmitter(StatsDConfig{Format: test.format, Tags: map[string]string{"env": "prod"}}, registry)
+			emitters[test.format] = emitter
+		}
+		test.update()
+		if got := lines(emitter); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
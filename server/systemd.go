@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"log/slog"
+	"net"
+	"tailscale-exporter/tailscaleclient"
+	"time"
+)
+
+// systemdListeners returns the sockets passed by systemd socket activation (LISTEN_FDS), if any.
+func systemdListeners() ([]net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("error on systemd socket activation: %w", err)
+	}
+	var sockets []net.Listener
+	for _, listener := range listeners {
+		// nil for passed fds that are not stream sockets
+		if listener != nil {
+			sockets = append(sockets, listener)
+		}
+	}
+	return sockets, nil
+}
+
+// NotifySystemd sends state to systemd for units with Type=notify. It is a no-op otherwise.
+func NotifySystemd(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		slog.Error("error on sd_notify", "err", err)
+	}
+}
+
+// RunWatchdog sends WATCHDOG=1 while check keeps answering, so systemd restarts the exporter
+// when status collection hangs. A failing but answering check still counts as alive.
+func RunWatchdog(ctx context.Context, check tailscaleclient.StatusProvider) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Error("error on systemd watchdog", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval/3)
+		_, err := check.Status(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			slog.Warn("status check hangs, skipping watchdog notification")
+		} else {
+			NotifySystemd(daemon.SdNotifyWatchdog)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval / 3):
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TextfileWriter periodically writes the gathered metrics to Dir for node_exporter's textfile collector.
+// go_, process_ and promhttp_ families are left out, node_exporter exports its own.
+type TextfileWriter struct {
+	Gatherer prometheus.Gatherer
+	Dir      string
+	Interval time.Duration
+}
+
+func (writer *TextfileWriter) Run(ctx context.Context) {
+	for {
+		if err := writer.Write(); err != nil {
+			slog.Error("error on write textfile", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(writer.Interval):
+		}
+	}
+}
+
+// Write replaces tailscale-exporter.prom atomically, so node_exporter never reads a partial file.
+func (writer *TextfileWriter) Write() error {
+	families, err := writer.Gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		slog.Error("error on gather metrics", "err", err)
+	}
+	tmp, err := os.CreateTemp(writer.Dir, ".tailscale-exporter.prom.*")
+	if err != nil {
+		return fmt.Errorf("error on create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	families = slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+		name := family.GetName()
+		return strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") || strings.HasPrefix(name, "promhttp_")
+	})
+	if err := WriteMetrics(tmp, families); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error on close temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("error on chmod temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(writer.Dir, "tailscale-exporter.prom")); err != nil {
+		return fmt.Errorf("error on rename temp file: %w", err)
+	}
+	return nil
+}
+
+// WriteMetrics writes families in the Prometheus text format.
+func WriteMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return fmt.Errorf("error on encode %s: %w", family.GetName(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"tailscale.com/client/local"
+	"tailscale.com/tsnet"
+)
+
+// StartTsnet joins the tailnet as its own node and returns a listener on its tailnet address,
+// a LocalAPI client of the embedded node and an HTTP client dialing through it.
+func StartTsnet(ctx context.Context, hostname string, stateDir string, port string) (net.Listener, *local.Client, *http.Client, error) {
+	server := &tsnet.Server{
+		Hostname:  hostname,
+		Dir:       stateDir,
+		Ephemeral: true,
+		UserLogf:  func(format string, args ...any) { slog.Info(fmt.Sprintf(format, args...), "component", "tsnet") },
+	}
+	if _, err := server.Up(ctx); err != nil {
+		return nil, nil, nil, fmt.Errorf("error on tsnet up: %w", err)
+	}
+	localClient, err := server.LocalClient()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error on tsnet local client: %w", err)
+	}
+	listener, err := server.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error on tsnet listen: %w", err)
+	}
+	return listener, localClient, server.HTTPClient(), nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServeWebhook serves /webhook on its own listener. Tailscale delivers webhooks from the internet,
+// which the tailnet listeners and their WhoIs access control never accept, so only the signature is checked here.
+func ServeWebhook(listenAddress string, handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", handler)
+	httpServer := &http.Server{
+		Addr:              listenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	slog.Info("start webhook listener!", "address", listenAddress)
+	slog.Error("webhook listener failed", "err", httpServer.ListenAndServe())
+	os.Exit(1)
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+const defaultCertDir = "/var/lib/tailscale/certs"
+
+func installService() error {
+	return fmt.Errorf("--service is only supported on Windows, use systemd or launchd")
+}
+
+func uninstallService() error {
+	return installService()
+}
+
+func runAsService(_ context.Context) (context.Context, func(), error) {
+	return nil, nil, installService()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const serviceName = "tailscale-exporter"
+
+// tailscaled keeps its state, certificates included, in %ProgramData%\Tailscale on Windows
+var defaultCertDir = filepath.Join(os.Getenv("ProgramData"), "Tailscale", "certs")
+
+// installService registers the exporter as an automatically started Windows service,
+// running with the current command line flags, --service=install replaced by --service=run.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error on find executable: %w", err)
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("error on connect to service manager: %w", err)
+	}
+	defer manager.Disconnect()
+	var args []string
+	for i := 1; i < len(os.Args); i++ {
+		switch arg := os.Args[i]; {
+		case arg == "--service":
+			// value in the next argument
+			i++
+		case strings.HasPrefix(arg, "--service="):
+		default:
+			args = append(args, arg)
+		}
+	}
+	service, err := manager.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Tailscale Exporter",
+		Description: "Prometheus exporter for Tailscale",
+		StartType:   mgr.StartAutomatic,
+	}, append(args, "--service=run")...)
+	if err != nil {
+		return fmt.Errorf("error on create service: %w", err)
+	}
+	defer service.Close()
+	slog.Info("service installed", "name", serviceName, "args", args)
+	return nil
+}
+
+func uninstallService() error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("error on connect to service manager: %w", err)
+	}
+	defer manager.Disconnect()
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("error on open service: %w", err)
+	}
+	defer service.Close()
+	if err := service.Delete(); err != nil {
+		return fmt.Errorf("error on delete service: %w", err)
+	}
+	slog.Info("service uninstalled", "name", serviceName)
+	return nil
+}
+
+// runAsService reports to the Windows service manager. The returned context is canceled when the
+// service is stopped, call done when the exporter finished so the service is reported as stopped.
+func runAsService(parent context.Context) (context.Context, func(), error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on detect service: %w", err)
+	}
+	if !isService {
+		return nil, nil, fmt.Errorf("--service=run must be started by the service manager, use --service=install")
+	}
+	ctx, cancel := context.WithCancel(parent)
+	handler := &serviceHandler{cancel: cancel, exited: make(chan struct{})}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := svc.Run(serviceName, handler); err != nil {
+			slog.Error("error on run service", "err", err)
+		}
+		cancel()
+	}()
+	return ctx, func() {
+		close(handler.exited)
+		<-stopped
+	}, nil
+}
+
+type serviceHandler struct {
+	cancel context.CancelFunc
+	exited chan struct{}
+}
+
+func (handler *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				handler.cancel()
+				<-handler.exited
+				return false, 0
+			}
+		case <-handler.exited:
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package tailscaleclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var StatusAgeDesc = prometheus.NewDesc("status_age_seconds", "Age of the cached tailscale status.", nil, nil)
+
+// StatusCache refreshes status in background so scrapes never wait for tailscale.
+// Status fails once the cached status is older than MaxAge, so peer series go stale instead of freezing.
+type StatusCache struct {
+	Provider StatusProvider
+	Interval time.Duration
+	Timeout  time.Duration
+	MaxAge   time.Duration
+
+	mu      sync.RWMutex
+	status  *Status
+	updated time.Time
+}
+
+func (cache *StatusCache) Run(ctx context.Context) {
+	for {
+		if err := cache.Update(ctx); err != nil {
+			slog.Error("status refresh failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cache.Interval):
+		}
+	}
+}
+
+// Update fetches the status once and caches it on success.
+func (cache *StatusCache) Update(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, cache.Timeout)
+	defer cancel()
+	status, err := cache.Provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	cache.mu.Lock()
+	cache.status = status
+	cache.updated = time.Now()
+	cache.mu.Unlock()
+	return nil
+}
+
+func (cache *StatusCache) Status(_ context.Context) (*Status, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if cache.status == nil {
+		return nil, fmt.Errorf("no tailscale status fetched yet")
+	}
+	if age := time.Since(cache.updated); age > cache.MaxAge {
+		return nil, fmt.Errorf("cached tailscale status is stale: %s old", age.Round(time.Second))
+	}
+	return cache.status, nil
+}
+
+func (cache *StatusCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- StatusAgeDesc
+}
+
+func (cache *StatusCache) Collect(ch chan<- prometheus.Metric) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if cache.status == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(StatusAgeDesc, prometheus.GaugeValue, time.Since(cache.updated).Seconds())
+}
+
+// SharedStatus makes concurrent scrapes share one in-flight status call instead of running tailscale in parallel.
+func SharedStatus(provider StatusProvider, timeout time.Duration) StatusProvider {
+	group := singleflight.Group{}
+	return StatusFunc(func(ctx context.Context) (*Status, error) {
+		result := group.DoChan("status", func() (interface{}, error) {
+			// not bound to the first caller: its cancellation must not fail everyone else
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return provider.Status(ctx)
+		})
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case r := <-result:
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			return r.Val.(*Status), nil
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package tailscaleclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math/rand/v2"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CLI runs the tailscale CLI against the tailscaled of Socket, the platform default if empty.
+type CLI struct {
+	Binary string
+	Socket string
+}
+
+func (cli CLI) Run(ctx context.Context, args ...string) ([]byte, error) {
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+	release, err := execLimiter.acquire(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	if cli.Socket != "" {
+		args = append([]string{"--socket=" + cli.Socket}, args...)
+	}
+	binary := cli.Binary
+	if binary == "" {
+		binary = "tailscale"
+	}
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w. stderr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+var (
+	ExecInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cli_in_flight",
+		Help: "Number of running tailscale CLI processes.",
+	})
+	ExecWaitSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cli_wait_seconds_total",
+		Help: "Time tailscale CLI calls waited for a free slot or their command's minimum interval.",
+	}, []string{"command"})
+)
+
+// execLimiter is shared by all CLIs, so the instances and collectors together stay within the limits.
+var execLimiter = &ExecLimiter{}
+
+// SetExecLimits allows at most concurrency tailscale CLI processes at once and starts each command,
+// e.g. status or netcheck, at most every minInterval. Zero values are unlimited.
+func SetExecLimits(concurrency int, minInterval time.Duration) {
+	execLimiter = &ExecLimiter{MinInterval: minInterval}
+	if concurrency > 0 {
+		execLimiter.slots = make(chan struct{}, concurrency)
+	}
+}
+
+// ExecLimiter keeps a burst of scrapes from forking a process per collector on a small router.
+type ExecLimiter struct {
+	MinInterval time.Duration
+
+	slots chan struct{}
+	mu    sync.Mutex
+	next  map[string]time.Time
+}
+
+// acquire waits for a slot and the minimum interval of command, the returned func releases the slot.
+func (limiter *ExecLimiter) acquire(ctx context.Context, command string) (func(), error) {
+	start := time.Now()
+	defer func() {
+		if waited := time.Since(start); waited > time.Millisecond {
+			ExecWaitSeconds.WithLabelValues(command).Add(waited.Seconds())
+		}
+	}()
+	if limiter.MinInterval > 0 {
+		limiter.mu.Lock()
+		if limiter.next == nil {
+			limiter.next = map[string]time.Time{}
+		}
+		// reserve the next start time, so waiting calls of one command are spread by MinInterval
+		at := start
+		if next := limiter.next[command]; next.After(start) {
+			at = next
+		}
+		limiter.next[command] = at.Add(limiter.MinInterval)
+		limiter.mu.Unlock()
+		if wait := at.Sub(start); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("error on wait for tailscale %s rate limit: %w", command, ctx.Err())
+			case <-time.After(wait):
+			}
+		}
+	}
+	if limiter.slots != nil {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error on wait for a tailscale CLI slot: %w", ctx.Err())
+		case limiter.slots <- struct{}{}:
+		}
+	}
+	ExecInFlight.Inc()
+	return func() {
+		ExecInFlight.Dec()
+		if limiter.slots != nil {
+			<-limiter.slots
+		}
+	}, nil
+}
+
+var StatusRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "status_retries_total",
+	Help: "Number of retried tailscale status calls, e.g. while tailscaled restarts.",
+})
+
+// ExecProvider runs `tailscale status -json`. Failed calls are retried up to Retries times
+// with exponential backoff and jitter, as long as ctx allows.
+type ExecProvider struct {
+	CLI     CLI
+	Retries int
+}
+
+func (provider *ExecProvider) Status(ctx context.Context) (*Status, error) {
+	for attempt := 0; ; attempt++ {
+		status, err := provider.statusOnce(ctx)
+		if err == nil || attempt >= provider.Retries {
+			return status, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(retryBackoff(attempt)):
+		}
+		StatusRetries.Inc()
+	}
+}
+
+// retryBackoff returns a random duration up to 100ms doubled per attempt, at most 5s
+func retryBackoff(attempt int) time.Duration {
+	return time.Duration(rand.Int64N(int64(min(time.Millisecond*100<<min(attempt, 6), time.Second*5))))
+}
+
+func (provider *ExecProvider) statusOnce(ctx context.Context) (*Status, error) {
+	stdout, err := provider.CLI.Run(ctx, "status", "-json")
+	if err != nil {
+		return nil, fmt.Errorf("error on tailscale status: %w", err)
+	}
+	status := Status{}
+	if err := json.Unmarshal(stdout, &status); err != nil {
+		return nil, fmt.Errorf("error on unmarshal: %w. stdout: %s", err, string(stdout))
+	}
+	return &status, nil
+}
+
+var pongRe = regexp.MustCompile(`(?m)^pong from (\S+) \(([^,)]+)[^)]*\) via (\S+) in (\S+)$`)
+
+type PingResult struct {
+	NodeName string
+	NodeIP   string
+	Via      string
+	Latency  time.Duration
+}
+
+// Path returns how the pong reached us: direct, derp or peer-relay.
+func (result *PingResult) Path() string {
+	switch {
+	case strings.HasPrefix(result.Via, "DERP("):
+		return "derp"
+	case strings.HasPrefix(result.Via, "peer-relay("):
+		return "peer-relay"
+	default:
+		return "direct"
+	}
+}
+
+// Ping runs a single `tailscale ping` to target.
+func (cli CLI) Ping(ctx context.Context, target string, timeout time.Duration) (*PingResult, error) {
+	stdout, err := cli.Run(ctx, "ping", "-c", "1", "--until-direct=false", "--timeout", timeout.String(), target)
+	if err != nil {
+		return nil, fmt.Errorf("error on tailscale ping %s: %w", target, err)
+	}
+	match := pongRe.FindSubmatch(stdout)
+	if match == nil {
+		return nil, fmt.Errorf("error on parse tailscale ping %s output: %s", target, string(stdout))
+	}
+	latency, err := time.ParseDuration(string(match[4]))
+	if err != nil {
+		return nil, fmt.Errorf("error on parse tailscale ping %s latency: %w", target, err)
+	}
+	return &PingResult{
+		NodeName: string(match[1]),
+		NodeIP:   string(match[2]),
+		Via:      string(match[3]),
+		Latency:  latency,
+	}, nil
+}
+
+// NetcheckReport is the subset of `tailscale netcheck --format=json` output we export.
+type NetcheckReport struct {
+	UDP                   bool                  `json:"UDP"`
+	IPv4                  bool                  `json:"IPv4"`
+	IPv6                  bool                  `json:"IPv6"`
+	MappingVariesByDestIP *bool                 `json:"MappingVariesByDestIP"`
+	UPnP                  *bool                 `json:"UPnP"`
+	PMP                   *bool                 `json:"PMP"`
+	PCP                   *bool                 `json:"PCP"`
+	PreferredDERP         int                   `json:"PreferredDERP"`
+	RegionLatency         map[int]time.Duration `json:"RegionLatency"`
+	RegionV4Latency       map[int]time.Duration `json:"RegionV4Latency"`
+	RegionV6Latency       map[int]time.Duration `json:"RegionV6Latency"`
+}
+
+// Netcheck runs `tailscale netcheck`.
+func (cli CLI) Netcheck(ctx context.Context) (*NetcheckReport, error) {
+	stdout, err := cli.Run(ctx, "netcheck", "--format=json")
+	if err != nil {
+		return nil, fmt.Errorf("error on tailscale netcheck: %w", err)
+	}
+	report := NetcheckReport{}
+	if err := json.Unmarshal(stdout, &report); err != nil {
+		return nil, fmt.Errorf("error on unmarshal netcheck: %w. stdout: %s", err, string(stdout))
+	}
+	return &report, nil
+}
+
+// VersionInfo is the subset of `tailscale version --json --daemon` output we export.
+type VersionInfo struct {
+	Short      string `json:"short"`
+	Long       string `json:"long"`
+	DaemonLong string `json:"daemonLong"`
+}
+
+// Version runs `tailscale version`, asking tailscaled for its version too.
+func (cli CLI) Version(ctx context.Context) (*VersionInfo, error) {
+	stdout, err := cli.Run(ctx, "version", "--json", "--daemon")
+	if err != nil {
+		return nil, fmt.Errorf("error on tailscale version: %w", err)
+	}
+	info := VersionInfo{}
+	if err := json.Unmarshal(stdout, &info); err != nil {
+		return nil, fmt.Errorf("error on unmarshal version: %w. stdout: %s", err, string(stdout))
+	}
+	return &info, nil
+}
@@ -0,0 +1,38 @@
+package tailscaleclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileProvider replays a status recorded by `tailscale-exporter record` or `tailscale status -json`,
+// to develop dashboards or reproduce bug reports without the original tailnet.
+// The file is read once, with Reload on every call, so it can be edited while running.
+type FileProvider struct {
+	Path   string
+	Reload bool
+
+	mu     sync.Mutex
+	status *Status
+}
+
+func (provider *FileProvider) Status(_ context.Context) (*Status, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.status != nil && !provider.Reload {
+		return provider.status, nil
+	}
+	raw, err := os.ReadFile(provider.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error on read status file: %w", err)
+	}
+	status := &Status{}
+	if err := json.Unmarshal(raw, status); err != nil {
+		return nil, fmt.Errorf("error on unmarshal status file %s: %w", provider.Path, err)
+	}
+	provider.status = status
+	return status, nil
+}
@@ -0,0 +1,267 @@
+package tailscaleclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+	"time"
+)
+
+// StatusWatcher keeps the status in memory, updated from the LocalAPI IPN bus instead of running
+// tailscale status on every scrape. Peer additions, removals and patches are applied as they arrive.
+// Traffic counters and connection details are not on the bus, a full resync every ResyncInterval refreshes them.
+type StatusWatcher struct {
+	LocalClient    *local.Client
+	ResyncInterval time.Duration
+	Timeout        time.Duration
+	// MaxAge fails Status when the bus was silent and resyncs failed for this long
+	MaxAge time.Duration
+	// Observe, if set, is called with every update, not only the scraped ones
+	Observe func(status *Status)
+
+	mu       sync.Mutex
+	model    *ipnstate.Status
+	nodeKeys map[tailcfg.NodeID]key.NodePublic
+	status   *Status
+	updated  time.Time
+}
+
+func (watcher *StatusWatcher) Run(ctx context.Context) {
+	for {
+		if err := watcher.watch(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("ipn bus watch failed, reconnecting", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func (watcher *StatusWatcher) watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bus, err := watcher.LocalClient.WatchIPNBus(ctx, ipn.NotifyInitialStatus|ipn.NotifyInitialState|ipn.NotifyPeerPatches|ipn.NotifyRateLimit)
+	if err != nil {
+		return fmt.Errorf("error on watch ipn bus: %w", err)
+	}
+	defer bus.Close()
+
+	notifies := make(chan ipn.Notify)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			notify, err := bus.Next()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case notifies <- notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	resync := time.NewTicker(watcher.ResyncInterval)
+	defer resync.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return fmt.Errorf("error on read ipn bus: %w", err)
+		case notify := <-notifies:
+			watcher.apply(&notify)
+		case <-resync.C:
+			if err := watcher.Resync(ctx); err != nil {
+				slog.Error("status resync failed", "err", err)
+			}
+		}
+	}
+}
+
+// Resync replaces the status with a full LocalAPI status.
+func (watcher *StatusWatcher) Resync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, watcher.Timeout)
+	defer cancel()
+	status, err := watcher.LocalClient.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("error on get status: %w", err)
+	}
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.setModel(status)
+	watcher.observeLocked()
+	return nil
+}
+
+func (watcher *StatusWatcher) setModel(status *ipnstate.Status) {
+	if status.Peer == nil {
+		// only allocated once a peer is added, null on a single node tailnet
+		status.Peer = map[key.NodePublic]*ipnstate.PeerStatus{}
+	}
+	watcher.model = status
+	watcher.nodeKeys = map[tailcfg.NodeID]key.NodePublic{}
+	for nodeKey, peer := range status.Peer {
+		watcher.nodeKeys[peer.NodeID] = nodeKey
+	}
+	watcher.status = nil
+	watcher.updated = time.Now()
+}
+
+func (watcher *StatusWatcher) apply(notify *ipn.Notify) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if notify.InitialStatus != nil {
+		watcher.setModel(notify.InitialStatus)
+	}
+	model := watcher.model
+	if model == nil {
+		return
+	}
+	if notify.State != nil {
+		model.BackendState = notify.State.String()
+		if *notify.State == ipn.Running {
+			// tailscaled forgets the auth URL once logged in
+			model.AuthURL = ""
+		}
+	}
+	if notify.BrowseToURL != nil {
+		model.AuthURL = *notify.BrowseToURL
+	}
+	if notify.SelfChange != nil {
+		model.Self = peerFromNode(notify.SelfChange, model.Self)
+		model.TailscaleIPs = model.Self.TailscaleIPs
+	}
+	for _, node := range notify.PeersChanged {
+		previous := model.Peer[watcher.nodeKeys[node.ID]]
+		// the node key rotated, the peer is keyed by the new one
+		delete(model.Peer, watcher.nodeKeys[node.ID])
+		model.Peer[node.Key] = peerFromNode(node, previous)
+		watcher.nodeKeys[node.ID] = node.Key
+	}
+	for _, change := range notify.PeerChangedPatch {
+		peer := model.Peer[watcher.nodeKeys[change.NodeID]]
+		if peer == nil {
+			continue
+		}
+		if change.Online != nil {
+			peer.Online = *change.Online
+		}
+		if change.LastSeen != nil {
+			peer.LastSeen = *change.LastSeen
+		}
+		if change.Endpoints != nil {
+			peer.Addrs = addrPortStrings(change.Endpoints)
+		}
+	}
+	for _, nodeID := range notify.PeersRemoved {
+		delete(model.Peer, watcher.nodeKeys[nodeID])
+		delete(watcher.nodeKeys, nodeID)
+	}
+	for userID, profile := range notify.UserProfiles {
+		if model.User == nil {
+			model.User = map[tailcfg.UserID]tailcfg.UserProfile{}
+		}
+		model.User[userID] = *profile.AsStruct()
+	}
+	watcher.status = nil
+	watcher.updated = time.Now()
+	watcher.observeLocked()
+}
+
+func (watcher *StatusWatcher) observeLocked() {
+	if watcher.Observe == nil {
+		return
+	}
+	status, err := watcher.convertLocked()
+	if err != nil {
+		slog.Error("error on convert status", "err", err)
+		return
+	}
+	watcher.Observe(status)
+}
+
+// peerFromNode converts a netmap node, keeping the engine fields of previous.
+func peerFromNode(node *tailcfg.Node, previous *ipnstate.PeerStatus) *ipnstate.PeerStatus {
+	peer := &ipnstate.PeerStatus{}
+	if previous != nil {
+		*peer = *previous
+	}
+	peer.ID = node.StableID
+	peer.NodeID = node.ID
+	peer.PublicKey = node.Key
+	peer.DNSName = node.Name
+	peer.UserID = node.User
+	peer.Created = node.Created
+	if node.Hostinfo.Valid() {
+		peer.HostName = node.Hostinfo.Hostname()
+		peer.OS = node.Hostinfo.OS()
+	}
+	peer.TailscaleIPs = nil
+	for _, prefix := range node.Addresses {
+		peer.TailscaleIPs = append(peer.TailscaleIPs, prefix.Addr())
+	}
+	allowedIPs := views.SliceOf(node.AllowedIPs)
+	peer.AllowedIPs = &allowedIPs
+	peer.ExitNodeOption = tsaddr.ContainsExitRoutes(allowedIPs)
+	tags := views.SliceOf(node.Tags)
+	peer.Tags = &tags
+	peer.Addrs = addrPortStrings(node.Endpoints)
+	if node.Online != nil {
+		peer.Online = *node.Online
+	}
+	if node.LastSeen != nil {
+		peer.LastSeen = *node.LastSeen
+	}
+	peer.KeyExpiry = nil
+	if !node.KeyExpiry.IsZero() {
+		keyExpiry := node.KeyExpiry
+		peer.KeyExpiry = &keyExpiry
+	}
+	return peer
+}
+
+func addrPortStrings(addrPorts []netip.AddrPort) []string {
+	addrs := make([]string, 0, len(addrPorts))
+	for _, addrPort := range addrPorts {
+		addrs = append(addrs, addrPort.String())
+	}
+	return addrs
+}
+
+// Status returns the current status in the `tailscale status -json` shape.
+func (watcher *StatusWatcher) Status(_ context.Context) (*Status, error) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if watcher.model == nil {
+		return nil, fmt.Errorf("no tailscale status received from the IPN bus yet")
+	}
+	if age := time.Since(watcher.updated); age > watcher.MaxAge {
+		return nil, fmt.Errorf("tailscale status from the IPN bus is stale: %s old", age.Round(time.Second))
+	}
+	return watcher.convertLocked()
+}
+
+// convertLocked converts the model to the `tailscale status -json` shape once per change.
+func (watcher *StatusWatcher) convertLocked() (*Status, error) {
+	if watcher.status == nil {
+		status, err := convertStatus(watcher.model)
+		if err != nil {
+			return nil, err
+		}
+		watcher.status = status
+	}
+	return watcher.status, nil
+}
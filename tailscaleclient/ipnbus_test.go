@@ -0,0 +1,35 @@
+package tailscaleclient
+
+import (
+	"net/netip"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"testing"
+)
+
+func TestStatusWatcherFirstPeer(t *testing.T) {
+	watcher := &StatusWatcher{}
+	// a single node tailnet has a null peer map
+	watcher.apply(&ipn.Notify{InitialStatus: &ipnstate.Status{BackendState: "Running"}})
+	watcher.apply(&ipn.Notify{PeersChanged: []*tailcfg.Node{{
+		ID:        1,
+		StableID:  "p1",
+		Key:       key.NewNode().Public(),
+		Name:      "peer1.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+	}}})
+	status, err := watcher.convertLocked()
+	if err != nil {
+		t.Fatalf("error on convert status: %v", err)
+	}
+	if len(status.Peer) != 1 {
+		t.Fatalf("got %d peers, want 1", len(status.Peer))
+	}
+	for _, peer := range status.Peer {
+		if peer.ID != "p1" || len(peer.TailscaleIPs) != 1 || peer.TailscaleIPs[0] != "100.64.0.2" {
+			t.Errorf("got peer %s with addresses %v, want p1 with 100.64.0.2", peer.ID, peer.TailscaleIPs)
+		}
+	}
+}
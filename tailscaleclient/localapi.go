@@ -0,0 +1,34 @@
+package tailscaleclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"tailscale.com/client/local"
+)
+
+// LocalAPIProvider fetches status over LocalAPI. The JSON shape is the same as `tailscale status -json`.
+type LocalAPIProvider struct {
+	Client *local.Client
+}
+
+func (provider *LocalAPIProvider) Status(ctx context.Context) (*Status, error) {
+	ipnStatus, err := provider.Client.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error on local api status: %w", err)
+	}
+	return convertStatus(ipnStatus)
+}
+
+// convertStatus converts a LocalAPI status to the `tailscale status -json` shape.
+func convertStatus(ipnStatus any) (*Status, error) {
+	raw, err := json.Marshal(ipnStatus)
+	if err != nil {
+		return nil, fmt.Errorf("error on marshal status: %w", err)
+	}
+	status := &Status{}
+	if err := json.Unmarshal(raw, status); err != nil {
+		return nil, fmt.Errorf("error on unmarshal: %w", err)
+	}
+	return status, nil
+}
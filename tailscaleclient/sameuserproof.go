@@ -0,0 +1,119 @@
+package tailscaleclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SameUserProofTransport reaches the LocalAPI of the macOS GUI clients, which listen on a localhost port
+// protected by a token instead of a unix socket. Both are discovered from the sameuserproof file
+// on every request, as they change when the client restarts. Use it with local.Client OmitAuth.
+type SameUserProofTransport struct {
+	// Dir holds the sameuserproof file, empty searches the App Store and standalone client locations
+	Dir string
+
+	mu        sync.Mutex
+	port      int
+	transport *http.Transport
+}
+
+func (transport *SameUserProofTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	port, token, err := transport.discover()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.SetBasicAuth("", token)
+	return transport.forPort(port).RoundTrip(req)
+}
+
+// forPort returns a transport dialing the localhost port, whatever the host of the request.
+func (transport *SameUserProofTransport) forPort(port int) *http.Transport {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.transport != nil && transport.port == port {
+		return transport.transport
+	}
+	if transport.transport != nil {
+		transport.transport.CloseIdleConnections()
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	dialer := &net.Dialer{}
+	transport.port = port
+	transport.transport = &http.Transport{DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}}
+	return transport.transport
+}
+
+func (transport *SameUserProofTransport) discover() (int, string, error) {
+	dirs := []string{transport.Dir}
+	if transport.Dir == "" {
+		dirs = sameUserProofDirs()
+	}
+	for _, dir := range dirs {
+		port, token, err := ReadSameUserProof(dir)
+		if err == nil {
+			return port, token, nil
+		}
+	}
+	return 0, "", fmt.Errorf("error on discover LocalAPI port: no sameuserproof file in %s", strings.Join(dirs, ", "))
+}
+
+// sameUserProofDirs returns the group containers of the App Store client, then the shared directory of the standalone one.
+func sameUserProofDirs() []string {
+	var dirs []string
+	if home, err := os.UserHomeDir(); err == nil {
+		containers, _ := filepath.Glob(filepath.Join(home, "Library", "Group Containers", "*tailscale.ipn.macos"))
+		dirs = append(dirs, containers...)
+	}
+	return append(dirs, "/Library/Tailscale")
+}
+
+// ReadSameUserProof returns the LocalAPI port and token of the macOS GUI client from dir.
+// The App Store client writes an empty sameuserproof-<port>-<token> file, the standalone client
+// an ipnport symlink to the port and the token into sameuserproof-<port>.
+func ReadSameUserProof(dir string) (int, string, error) {
+	if portLink, err := os.Readlink(filepath.Join(dir, "ipnport")); err == nil {
+		port, err := strconv.Atoi(portLink)
+		if err != nil {
+			return 0, "", fmt.Errorf("error on parse ipnport %q: %w", portLink, err)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "sameuserproof-"+portLink))
+		if err != nil {
+			return 0, "", fmt.Errorf("error on read sameuserproof: %w", err)
+		}
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return 0, "", fmt.Errorf("empty token in sameuserproof-%s", portLink)
+		}
+		return port, token, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, "", fmt.Errorf("error on read sameuserproof dir: %w", err)
+	}
+	for _, entry := range entries {
+		rest, ok := strings.CutPrefix(entry.Name(), "sameuserproof-")
+		if !ok {
+			continue
+		}
+		portPart, token, ok := strings.Cut(rest, "-")
+		if !ok || token == "" {
+			continue
+		}
+		port, err := strconv.Atoi(portPart)
+		if err != nil {
+			continue
+		}
+		return port, token, nil
+	}
+	return 0, "", fmt.Errorf("no sameuserproof file in %s", dir)
+}
@@ -0,0 +1,138 @@
+package tailscaleclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewSSHClientConfig authenticates as user with the private key of keyFile and verifies hosts against knownHostsFile.
+func NewSSHClientConfig(user string, keyFile string, knownHostsFile string, timeout time.Duration) (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error on read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error on parse ssh key %s: %w", keyFile, err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error on read known hosts: %w", err)
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+// SSHProvider runs Command, `tailscale status --json` by default, on a remote host over SSH,
+// for appliances without the exporter. The connection is kept open between calls and redialed after errors.
+type SSHProvider struct {
+	Address string
+	Config  *ssh.ClientConfig
+	Command string
+	// Dial connects to Address, e.g. through a tsnet node. Nil is a plain TCP dial.
+	Dial func(ctx context.Context, network string, address string) (net.Conn, error)
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func (provider *SSHProvider) Status(ctx context.Context) (*Status, error) {
+	stdout, err := provider.run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error on remote tailscale status of %s: %w", provider.Address, err)
+	}
+	status := Status{}
+	if err := json.Unmarshal(stdout, &status); err != nil {
+		return nil, fmt.Errorf("error on unmarshal: %w. stdout: %s", err, string(stdout))
+	}
+	return &status, nil
+}
+
+func (provider *SSHProvider) run(ctx context.Context) ([]byte, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	client, err := provider.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		provider.reset()
+		return nil, fmt.Errorf("error on ssh session: %w", err)
+	}
+	defer session.Close()
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	command := provider.Command
+	if command == "" {
+		command = "tailscale status --json"
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case <-ctx.Done():
+		// a hung remote command would otherwise block the next scrapes, a fresh connection is cheaper
+		provider.reset()
+		return nil, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			var exitError *ssh.ExitError
+			if !errors.As(err, &exitError) {
+				provider.reset()
+			}
+			return nil, fmt.Errorf("%w. stderr: %s", err, stderr.String())
+		}
+	}
+	return stdout.Bytes(), nil
+}
+
+func (provider *SSHProvider) connect(ctx context.Context) (*ssh.Client, error) {
+	if provider.client != nil {
+		return provider.client, nil
+	}
+	dial := provider.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	address := provider.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error on dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, provider.Config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error on ssh handshake: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	provider.client = ssh.NewClient(sshConn, channels, requests)
+	return provider.client, nil
+}
+
+func (provider *SSHProvider) reset() {
+	if provider.client != nil {
+		_ = provider.client.Close()
+		provider.client = nil
+	}
+}
@@ -0,0 +1,119 @@
+// Package tailscaleclient reads the status of a tailscaled through the tailscale CLI or LocalAPI.
+package tailscaleclient
+
+import (
+	"context"
+	"tailscale.com/tailcfg"
+	"time"
+)
+
+// StatusProvider returns the current status of a tailscaled, in the `tailscale status -json` shape.
+type StatusProvider interface {
+	Status(ctx context.Context) (*Status, error)
+}
+
+// StatusFunc adapts a function to StatusProvider.
+type StatusFunc func(ctx context.Context) (*Status, error)
+
+func (f StatusFunc) Status(ctx context.Context) (*Status, error) {
+	return f(ctx)
+}
+
+// FakeProvider returns canned results, for tests and embedding without a tailscaled.
+type FakeProvider struct {
+	Result *Status
+	Err    error
+}
+
+func (provider *FakeProvider) Status(_ context.Context) (*Status, error) {
+	return provider.Result, provider.Err
+}
+
+// Status is the part of `tailscale status -json` used by the exporter.
+type Status struct {
+	Version      string   `json:"Version"`
+	TUN          bool     `json:"TUN"`
+	BackendState string   `json:"BackendState"`
+	AuthURL      string   `json:"AuthURL"`
+	TailscaleIPs []string `json:"TailscaleIPs"`
+	Health       []string `json:"Health"`
+	Self         struct {
+		ID             string                 `json:"ID"`
+		PublicKey      string                 `json:"PublicKey"`
+		HostName       string                 `json:"HostName"`
+		DNSName        string                 `json:"DNSName"`
+		OS             string                 `json:"OS"`
+		UserID         int                    `json:"UserID"`
+		TailscaleIPs   []string               `json:"TailscaleIPs"`
+		AllowedIPs     []string               `json:"AllowedIPs"`
+		Tags           []string               `json:"Tags"`
+		Addrs          []string               `json:"Addrs"`
+		CurAddr        string                 `json:"CurAddr"`
+		Relay          string                 `json:"Relay"`
+		RxBytes        int                    `json:"RxBytes"`
+		TxBytes        int                    `json:"TxBytes"`
+		Created        time.Time              `json:"Created"`
+		LastWrite      time.Time              `json:"LastWrite"`
+		LastSeen       time.Time              `json:"LastSeen"`
+		LastHandshake  time.Time              `json:"LastHandshake"`
+		Online         bool                   `json:"Online"`
+		ExitNode       bool                   `json:"ExitNode"`
+		ExitNodeOption bool                   `json:"ExitNodeOption"`
+		Active         bool                   `json:"Active"`
+		PeerAPIURL     []string               `json:"PeerAPIURL"`
+		Capabilities   []string               `json:"Capabilities"`
+		CapMap         map[string]interface{} `json:"CapMap"`
+		InNetworkMap   bool                   `json:"InNetworkMap"`
+		InMagicSock    bool                   `json:"InMagicSock"`
+		InEngine       bool                   `json:"InEngine"`
+	} `json:"Self"`
+	MagicDNSSuffix string `json:"MagicDNSSuffix"`
+	CurrentTailnet struct {
+		Name            string `json:"Name"`
+		MagicDNSSuffix  string `json:"MagicDNSSuffix"`
+		MagicDNSEnabled bool   `json:"MagicDNSEnabled"`
+	} `json:"CurrentTailnet"`
+	Peer map[string]Peer `json:"Peer"`
+	User map[string]struct {
+		ID            int    `json:"ID"`
+		LoginName     string `json:"LoginName"`
+		DisplayName   string `json:"DisplayName"`
+		ProfilePicURL string `json:"ProfilePicURL"`
+	} `json:"User"`
+	// ClientVersion is nil until the control server told the client about updates
+	ClientVersion *tailcfg.ClientVersion `json:"ClientVersion"`
+}
+
+// Peer is an entry of the Peer map of `tailscale status -json`.
+// It carries no latency, ipnstate.PeerStatus has none and the DERP latencies of tailcfg.NetInfo are not part of the status.
+// Latency comes from the netcheck collector (DERP regions) and the probes (tailscale ping).
+type Peer struct {
+	ID             string    `json:"ID"`
+	PublicKey      string    `json:"PublicKey"`
+	HostName       string    `json:"HostName"`
+	DNSName        string    `json:"DNSName"`
+	OS             string    `json:"OS"`
+	UserID         int       `json:"UserID"`
+	TailscaleIPs   []string  `json:"TailscaleIPs"`
+	AllowedIPs     []string  `json:"AllowedIPs"`
+	Tags           []string  `json:"Tags"`
+	Addrs          []string  `json:"Addrs"`
+	CurAddr        string    `json:"CurAddr"`
+	Relay          string    `json:"Relay"`
+	RxBytes        int       `json:"RxBytes"`
+	TxBytes        int       `json:"TxBytes"`
+	Created        time.Time `json:"Created"`
+	LastWrite      time.Time `json:"LastWrite"`
+	LastSeen       time.Time `json:"LastSeen"`
+	LastHandshake  time.Time `json:"LastHandshake"`
+	Online         bool      `json:"Online"`
+	ExitNode       bool      `json:"ExitNode"`
+	ExitNodeOption bool      `json:"ExitNodeOption"`
+	Active         bool      `json:"Active"`
+	PeerAPIURL     []string  `json:"PeerAPIURL"`
+	Capabilities   []string  `json:"Capabilities"`
+	InNetworkMap   bool      `json:"InNetworkMap"`
+	InMagicSock    bool      `json:"InMagicSock"`
+	InEngine       bool      `json:"InEngine"`
+	KeyExpiry      time.Time `json:"KeyExpiry"`
+}